@@ -4,15 +4,35 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"romrepo/internal/sshconfig"
 )
 
 type Config struct {
-	Server  ServerConfig `yaml:"server"`
-	Clients []Client     `yaml:"clients"`
+	Server       ServerConfig `yaml:"server"`
+	Clients      []Client     `yaml:"clients"`
+	RateLimit    string       `yaml:"rate_limit,omitempty"` // shared by all transfers, e.g. "20M"
+	SFTP         SFTPConfig   `yaml:"sftp,omitempty"`
+	CompareMtime bool         `yaml:"compare_mtime,omitempty"` // flag device copies whose size or mtime differ as stale
+}
+
+// SFTPConfig tunes how many writes a push keeps in flight. Zero values use
+// the defaults; raising them helps on high-latency links.
+type SFTPConfig struct {
+	Requests   int `yaml:"requests,omitempty"`    // outstanding writes per file, default 64
+	PacketSize int `yaml:"packet_size,omitempty"` // bytes per write, default 32768
 }
 
+// MaxSFTPPacket is the largest packet_size accepted, the size every SFTP
+// server must support. Larger packets come back short from servers that cap
+// reads, which breaks resume checks and device-to-device copies.
+const MaxSFTPPacket = 32768
+
 type ServerConfig struct {
 	ROMDir   string    `yaml:"rom_dir"`
 	Consoles []Console `yaml:"consoles"`
@@ -26,18 +46,165 @@ type Console struct {
 
 type Client struct {
 	Name       string            `yaml:"name"`
+	Transport  string            `yaml:"transport,omitempty"` // "ssh" (default), "ftp", "ftps" or "local" for a mounted card or drive
 	Host       string            `yaml:"host"`
 	Port       int               `yaml:"port"`
 	User       string            `yaml:"user"`
 	Auth       AuthConfig        `yaml:"auth"`
 	ROMDir     string            `yaml:"rom_dir"`
 	ConsoleDirs map[string]string `yaml:"console_dirs,omitempty"`
+	Groups     []string          `yaml:"groups,omitempty"` // tags such as "living-room" or "handhelds"
+	Verify     string            `yaml:"verify,omitempty"` // "auto" (default), "hash" or "off"
+	RateLimit  string            `yaml:"rate_limit,omitempty"` // per second, e.g. "2M" or "500K"
+	Concurrency int              `yaml:"concurrency,omitempty"` // transfers at once, default 1
+	Tar        string            `yaml:"tar,omitempty"`    // "auto" (default) sends batches of small ROMs as a tar stream; "off"
+	Delta      string            `yaml:"delta,omitempty"`  // "auto" (default) sends only changed blocks of updated ROMs; "off"
+	DeltaHelper string           `yaml:"delta_helper,omitempty"` // command run on the device for delta transfers, default "romrepo"
+	FileMode   string            `yaml:"file_mode,omitempty"` // octal mode for pushed ROMs, e.g. "0664"; default leaves the device's umask
+	DirMode    string            `yaml:"dir_mode,omitempty"`  // octal mode for directories created on the device, e.g. "0775"
+	Group      string            `yaml:"group,omitempty"`     // group name or ID given to pushed ROMs and created directories
+	ProxyJump  string            `yaml:"proxy_jump,omitempty"` // jump hosts as "[user@]host[:port]", comma separated; "none" ignores ~/.ssh/config
+}
+
+// IsLocal reports whether the client is a directory on this machine rather
+// than an SSH host.
+func (c Client) IsLocal() bool {
+	return c.Transport == "local"
+}
+
+// IsFTP reports whether the client is reached over FTP or FTPS.
+func (c Client) IsFTP() bool {
+	return c.Transport == "ftp" || c.Transport == "ftps"
+}
+
+// IsSSH reports whether the client is reached over SSH.
+func (c Client) IsSSH() bool {
+	return c.Transport == "" || c.Transport == "ssh"
+}
+
+// ResolveSSH returns the client with what ~/.ssh/config says about its
+// Host filled in. HostName replaces an alias, and Port, User, the key and
+// ProxyJump come from there wherever the client leaves them unset, a port
+// of 22 counting as unset. Other transports are returned as they are.
+func (c Client) ResolveSSH() Client {
+	if !c.IsSSH() {
+		return c
+	}
+	h := sshconfig.Default().Lookup(c.Host)
+	if h.HostName != "" {
+		c.Host = h.HostName
+	}
+	if (c.Port == 0 || c.Port == 22) && h.Port != 0 {
+		c.Port = h.Port
+	}
+	if c.User == "" {
+		c.User = h.User
+	}
+	if c.Auth.KeyPath == "" && len(h.IdentityFiles) > 0 {
+		c.Auth.KeyPath = h.IdentityFiles[0]
+		for _, f := range h.IdentityFiles {
+			if _, err := os.Stat(f); err == nil {
+				c.Auth.KeyPath = f
+				break
+			}
+		}
+	}
+	if c.ProxyJump == "" {
+		c.ProxyJump = h.ProxyJump
+	}
+	return c
+}
+
+// MaxConcurrency caps a client's concurrency. Each transfer holds its own
+// SFTP session and servers limit how many one connection may open.
+const MaxConcurrency = 8
+
+// Transfers returns how many transfers may run on the client at once.
+func (c Client) Transfers() int {
+	return max(1, c.Concurrency)
+}
+
+// InGroup reports whether the client is tagged with the given group.
+func (c Client) InGroup(group string) bool {
+	return slices.Contains(c.Groups, group)
+}
+
+// ConsoleDir returns the directory on the client that holds ROMs for the
+// given server console directory, honouring any per-console override.
+func (c Client) ConsoleDir(consoleDir string) string {
+	if override, ok := c.ConsoleDirs[consoleDir]; ok {
+		return override
+	}
+	return filepath.Join(c.ROMDir, consoleDir)
 }
 
 type AuthConfig struct {
-	Method     string `yaml:"method"` // "key" or "password"
-	KeyPath    string `yaml:"key_path,omitempty"`
-	Password   string `yaml:"password,omitempty"`
+	Method      string            `yaml:"method"` // "agent", "key" or "password", or several tried in order such as "agent,key"; FTP clients use "password" or "anonymous"
+	KeyPath     string            `yaml:"key_path,omitempty"`
+	Password    string            `yaml:"password,omitempty"`
+	Passphrases map[string]string `yaml:"-"` // for encrypted keys, by key path; only ever held in memory
+}
+
+// SSHAuthMethods lists the accepted SSH auth methods.
+var SSHAuthMethods = []string{"agent", "key", "password"}
+
+// Methods returns the auth methods to try, in order.
+func (a AuthConfig) Methods() []string {
+	var methods []string
+	for _, m := range strings.Split(a.Method, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// Uses reports whether method is one of the auth methods to try.
+func (a AuthConfig) Uses(method string) bool {
+	return slices.Contains(a.Methods(), method)
+}
+
+// ValidateSSH checks that an SSH client names at least one known auth
+// method and none twice.
+func (a AuthConfig) ValidateSSH() error {
+	methods := a.Methods()
+	if len(methods) == 0 {
+		return fmt.Errorf("required")
+	}
+	for i, m := range methods {
+		if !slices.Contains(SSHAuthMethods, m) {
+			return fmt.Errorf("unknown method %q, expected %s", m, strings.Join(SSHAuthMethods, ", "))
+		}
+		if slices.Contains(methods[:i], m) {
+			return fmt.Errorf("%q listed twice", m)
+		}
+	}
+	return nil
+}
+
+// GroupNames returns every group used by a client, sorted.
+func (c *Config) GroupNames() []string {
+	var names []string
+	for _, cl := range c.Clients {
+		for _, g := range cl.Groups {
+			if !slices.Contains(names, g) {
+				names = append(names, g)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ClientsInGroup returns the clients tagged with the given group, in config order.
+func (c *Config) ClientsInGroup(group string) []Client {
+	var clients []Client
+	for _, cl := range c.Clients {
+		if cl.InGroup(group) {
+			clients = append(clients, cl)
+		}
+	}
+	return clients
 }
 
 func DefaultConfig() *Config {
@@ -73,6 +240,15 @@ func defaultConfigPath() string {
 	return filepath.Join(home, ".config", "romrepo", "config.yaml")
 }
 
+// StatePath returns the path of a state file kept alongside the config file,
+// such as the transfer queue.
+func StatePath(configPath, name string) string {
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	return filepath.Join(filepath.Dir(configPath), name)
+}
+
 func Load(path string) (*Config, error) {
 	if path == "" {
 		path = defaultConfigPath()
@@ -125,6 +301,15 @@ func Validate(cfg *Config) error {
 	if cfg.Server.ROMDir == "" {
 		return fmt.Errorf("server.rom_dir is required")
 	}
+	if _, err := ParseRate(cfg.RateLimit); err != nil {
+		return fmt.Errorf("rate_limit: %w", err)
+	}
+	if cfg.SFTP.Requests < 0 {
+		return fmt.Errorf("sftp.requests must not be negative")
+	}
+	if cfg.SFTP.PacketSize < 0 || cfg.SFTP.PacketSize > MaxSFTPPacket {
+		return fmt.Errorf("sftp.packet_size must be between 0 (default) and %d", MaxSFTPPacket)
+	}
 	if len(cfg.Server.Consoles) == 0 {
 		return fmt.Errorf("at least one console must be configured")
 	}
@@ -132,15 +317,123 @@ func Validate(cfg *Config) error {
 		if c.Name == "" {
 			return fmt.Errorf("client[%d].name is required", i)
 		}
-		if c.Host == "" {
-			return fmt.Errorf("client[%d].host is required", i)
+		switch c.Transport {
+		case "", "ssh":
+			if c.Host == "" {
+				return fmt.Errorf("client[%d].host is required", i)
+			}
+			if c.Port == 0 {
+				cfg.Clients[i].Port = 22
+			}
+			if c.ResolveSSH().User == "" {
+				return fmt.Errorf("client[%d].user is required", i)
+			}
+			if err := c.Auth.ValidateSSH(); err != nil {
+				return fmt.Errorf("client[%d].auth.method: %w", i, err)
+			}
+		case "ftp", "ftps":
+			if c.Host == "" {
+				return fmt.Errorf("client[%d].host is required", i)
+			}
+			if c.Port == 0 {
+				cfg.Clients[i].Port = 21
+			}
+			switch c.Auth.Method {
+			case "anonymous":
+			case "password":
+				if c.User == "" {
+					return fmt.Errorf("client[%d].user is required", i)
+				}
+			default:
+				return fmt.Errorf("client[%d].auth.method must be password or anonymous for FTP", i)
+			}
+		case "local":
+			if !filepath.IsAbs(c.ROMDir) {
+				return fmt.Errorf("client[%d].rom_dir must be an absolute path for a local client", i)
+			}
+		default:
+			return fmt.Errorf("client[%d].transport must be ssh, ftp, ftps or local", i)
+		}
+		switch c.Verify {
+		case "", "auto", "hash", "off":
+		default:
+			return fmt.Errorf("client[%d].verify must be auto, hash or off", i)
+		}
+		switch c.Tar {
+		case "", "auto", "off":
+		default:
+			return fmt.Errorf("client[%d].tar must be auto or off", i)
+		}
+		switch c.Delta {
+		case "", "auto", "off":
+		default:
+			return fmt.Errorf("client[%d].delta must be auto or off", i)
+		}
+		if _, err := ParseRate(c.RateLimit); err != nil {
+			return fmt.Errorf("client[%d].rate_limit: %w", i, err)
+		}
+		if _, err := ParseMode(c.FileMode); err != nil {
+			return fmt.Errorf("client[%d].file_mode: %w", i, err)
 		}
-		if c.Port == 0 {
-			cfg.Clients[i].Port = 22
+		if _, err := ParseMode(c.DirMode); err != nil {
+			return fmt.Errorf("client[%d].dir_mode: %w", i, err)
 		}
-		if c.User == "" {
-			return fmt.Errorf("client[%d].user is required", i)
+		if c.IsFTP() && (c.FileMode != "" || c.DirMode != "" || c.Group != "") {
+			return fmt.Errorf("client[%d]: file_mode, dir_mode and group are not supported over FTP", i)
+		}
+		if c.Concurrency < 0 || c.Concurrency > MaxConcurrency {
+			return fmt.Errorf("client[%d].concurrency must be between 0 (one at a time) and %d", i, MaxConcurrency)
+		}
+		for _, g := range c.Groups {
+			if g == "" {
+				return fmt.Errorf("client[%d].groups contains an empty name", i)
+			}
 		}
 	}
 	return nil
 }
+
+// ParseMode converts an octal permission string such as "0644" or "755"
+// into a file mode. "" means none was set and returns 0.
+func ParseMode(s string) (os.FileMode, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v == 0 || v > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q, expected octal such as 0644", s)
+	}
+	return os.FileMode(v), nil
+}
+
+// ParseRate converts a rate limit such as "500K", "2M" or "1.5MB/s" into
+// bytes per second. Suffixes are binary multiples; "" and "0" mean no limit.
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "/S")
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+	if v == "" {
+		return 0, nil
+	}
+
+	mult := 1.0
+	switch v[len(v)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(n * mult), nil
+}
@@ -0,0 +1,57 @@
+package delta
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Run is the helper run on a device, as "romrepo delta sig BLOCK FILE" to
+// print FILE's signature or "romrepo delta apply BLOCK OLD OUT" to rebuild
+// OUT from OLD and a delta read from stdin.
+func Run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 3 {
+		return errors.New("usage: romrepo delta sig BLOCK FILE | apply BLOCK OLD OUT")
+	}
+	blockSize, err := strconv.Atoi(args[1])
+	if err != nil || blockSize < MinBlock || blockSize > MaxBlock {
+		return fmt.Errorf("invalid block size %q", args[1])
+	}
+
+	switch {
+	case args[0] == "sig" && len(args) == 3:
+		f, err := os.Open(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sig, err := Sign(f, blockSize)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", args[2], err)
+		}
+		return WriteSignature(stdout, sig)
+
+	case args[0] == "apply" && len(args) == 4:
+		old, err := os.Open(args[2])
+		if err != nil {
+			return err
+		}
+		defer old.Close()
+		out, err := os.Create(args[3])
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := Apply(old, blockSize, stdin, out); err != nil {
+			os.Remove(args[3])
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("syncing %s: %w", args[3], err)
+		}
+		return out.Close()
+	}
+	return fmt.Errorf("unknown delta command %q", args[0])
+}
@@ -0,0 +1,404 @@
+// Package delta implements rsync-style delta transfers. The device
+// describes the file it already has as a list of block signatures, the
+// sender finds those blocks anywhere in the new file with a rolling
+// checksum, and only the data between matches is sent.
+package delta
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Block size bounds. BlockSize picks one near the square root of the file
+// size, as rsync does, which balances signature size against match
+// granularity.
+const (
+	MinBlock = 2 << 10
+	MaxBlock = 128 << 10
+)
+
+// maxLiteral is how much unmatched data is buffered before it is sent.
+const maxLiteral = 1 << 20
+
+// Delta stream opcodes.
+const (
+	opCopy    = 'C' // uint32 first block, uint32 block count
+	opLiteral = 'L' // uint32 length, then the data
+	opEnd     = 'E' // uint64 size of the rebuilt file
+)
+
+// ErrCorrupt is returned by Apply for a malformed or truncated delta.
+var ErrCorrupt = errors.New("corrupt delta stream")
+
+// BlockSize returns the block size to use for a file of the given size.
+func BlockSize(size int64) int {
+	b := int(math.Sqrt(float64(size)))
+	b = (b + 1023) &^ 1023
+	return min(max(b, MinBlock), MaxBlock)
+}
+
+// Signature describes the device's copy of a file block by block.
+type Signature struct {
+	Size      int64
+	BlockSize int
+	Blocks    []Sig
+}
+
+// Sig is the signature of one block.
+type Sig struct {
+	Weak   uint32
+	Strong [md5.Size]byte
+}
+
+// lastLen returns the length of the final block, which may be short.
+func (s *Signature) lastLen() int {
+	if len(s.Blocks) == 0 {
+		return 0
+	}
+	return int(s.Size - int64(len(s.Blocks)-1)*int64(s.BlockSize))
+}
+
+// weakSum is the rsync rolling checksum of a window of data.
+type weakSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newWeakSum(p []byte) weakSum {
+	w := weakSum{n: uint32(len(p))}
+	for i, c := range p {
+		w.a += uint32(c)
+		w.b += uint32(len(p)-i) * uint32(c)
+	}
+	return w
+}
+
+// roll slides the window one byte, dropping out and taking in.
+func (w *weakSum) roll(out, in byte) {
+	w.a += uint32(in) - uint32(out)
+	w.b += w.a - w.n*uint32(out)
+}
+
+func (w weakSum) sum() uint32 {
+	return w.a&0xffff | w.b<<16
+}
+
+// Sign reads r in blocks of blockSize and returns its signature.
+func Sign(r io.Reader, blockSize int) (*Signature, error) {
+	sig := &Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Size += int64(n)
+			sig.Blocks = append(sig.Blocks, Sig{Weak: newWeakSum(buf[:n]).sum(), Strong: md5.Sum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteSignature writes sig as a "size block-size" line followed by one
+// line of hex weak and strong sums per block.
+func WriteSignature(w io.Writer, sig *Signature) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d %d\n", sig.Size, sig.BlockSize)
+	for _, s := range sig.Blocks {
+		fmt.Fprintf(bw, "%08x %x\n", s.Weak, s.Strong)
+	}
+	return bw.Flush()
+}
+
+// ParseSignature reads the output of WriteSignature.
+func ParseSignature(r io.Reader) (*Signature, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("parsing signature: empty")
+	}
+	sig := &Signature{}
+	if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &sig.Size, &sig.BlockSize); err != nil || sig.BlockSize <= 0 {
+		return nil, fmt.Errorf("parsing signature header %q", scanner.Text())
+	}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		weakHex, strongHex, ok := strings.Cut(line, " ")
+		weak, err := strconv.ParseUint(weakHex, 16, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("parsing signature line %q", line)
+		}
+		b := Sig{Weak: uint32(weak)}
+		if n, err := hex.Decode(b.Strong[:], []byte(strongHex)); err != nil || n != md5.Size {
+			return nil, fmt.Errorf("parsing signature line %q", line)
+		}
+		sig.Blocks = append(sig.Blocks, b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if want := (sig.Size + int64(sig.BlockSize) - 1) / int64(sig.BlockSize); int64(len(sig.Blocks)) != want {
+		return nil, fmt.Errorf("parsing signature: %d blocks, expected %d", len(sig.Blocks), want)
+	}
+	return sig, nil
+}
+
+// Diff reads the new file from r and writes a delta against sig to w. Runs
+// of matching blocks become copies and everything else is sent as literal
+// data. progress, if set, is called with the number of bytes of r consumed
+// so far.
+func Diff(ctx context.Context, r io.Reader, sig *Signature, w io.Writer, progress func(int64)) error {
+	blockSize := sig.BlockSize
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for k, b := range sig.Blocks {
+		index[b.Weak] = append(index[b.Weak], k)
+	}
+	last := len(sig.Blocks) - 1
+	lastLen := sig.lastLen()
+
+	e := &encoder{w: bufio.NewWriterSize(w, 64<<10)}
+	var (
+		buf      []byte // data read but not yet sent or matched
+		lit      int    // start of the pending literal in buf
+		i        int    // start of the window in buf
+		consumed int64
+		eof      bool
+		total    int64
+		weak     weakSum
+		rolling  bool
+	)
+	chunk := make([]byte, 256<<10)
+	flush := func(end int) error {
+		if err := e.literal(buf[lit:end]); err != nil {
+			return err
+		}
+		total += int64(end - lit)
+		lit = end
+		return nil
+	}
+	emit := func(k, n int) error {
+		if err := flush(i); err != nil {
+			return err
+		}
+		if err := e.copy(k); err != nil {
+			return err
+		}
+		total += int64(n)
+		i += n
+		lit = i
+		rolling = false
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !eof && len(buf)-i <= blockSize {
+			buf = append(buf[:0], buf[lit:]...)
+			i -= lit
+			lit = 0
+			for !eof && len(buf)-i <= blockSize {
+				n, err := r.Read(chunk)
+				buf = append(buf, chunk[:n]...)
+				consumed += int64(n)
+				if err == io.EOF {
+					eof = true
+				} else if err != nil {
+					return fmt.Errorf("reading file: %w", err)
+				}
+			}
+			if progress != nil {
+				progress(consumed - int64(len(buf)-i))
+			}
+		}
+
+		avail := len(buf) - i
+		if avail < blockSize {
+			// Only the device's short final block can match what is left,
+			// and only at the very end.
+			if lastLen < blockSize && avail >= lastLen && lastLen > 0 {
+				i = len(buf) - lastLen
+				if match(sig, index, newWeakSum(buf[i:]).sum(), buf[i:]) == last {
+					if err := emit(last, lastLen); err != nil {
+						return err
+					}
+				}
+			}
+			break
+		}
+
+		window := buf[i : i+blockSize]
+		if !rolling {
+			weak = newWeakSum(window)
+			rolling = true
+		}
+		if k := match(sig, index, weak.sum(), window); k >= 0 {
+			if err := emit(k, blockSize); err != nil {
+				return err
+			}
+			continue
+		}
+		if avail > blockSize {
+			weak.roll(buf[i], buf[i+blockSize])
+		} else {
+			rolling = false
+		}
+		i++
+
+		if i-lit >= maxLiteral {
+			if err := flush(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(len(buf)); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(consumed)
+	}
+	return e.end(total)
+}
+
+// match returns the block whose signature matches window, or -1.
+func match(sig *Signature, index map[uint32][]int, weak uint32, window []byte) int {
+	candidates := index[weak]
+	if len(candidates) == 0 {
+		return -1
+	}
+	strong := md5.Sum(window)
+	for _, k := range candidates {
+		if sig.Blocks[k].Strong == strong {
+			return k
+		}
+	}
+	return -1
+}
+
+// encoder writes delta opcodes, merging consecutive block copies.
+type encoder struct {
+	w          *bufio.Writer
+	start, run int // pending copy of run blocks from start
+}
+
+func (e *encoder) copy(k int) error {
+	if e.run > 0 && e.start+e.run == k {
+		e.run++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.start, e.run = k, 1
+	return nil
+}
+
+func (e *encoder) flushCopy() error {
+	if e.run == 0 {
+		return nil
+	}
+	var hdr [9]byte
+	hdr[0] = opCopy
+	binary.BigEndian.PutUint32(hdr[1:], uint32(e.start))
+	binary.BigEndian.PutUint32(hdr[5:], uint32(e.run))
+	e.run = 0
+	return e.write(hdr[:])
+}
+
+func (e *encoder) literal(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	var hdr [5]byte
+	hdr[0] = opLiteral
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(p)))
+	if err := e.write(hdr[:]); err != nil {
+		return err
+	}
+	return e.write(p)
+}
+
+func (e *encoder) end(size int64) error {
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	var hdr [9]byte
+	hdr[0] = opEnd
+	binary.BigEndian.PutUint64(hdr[1:], uint64(size))
+	if err := e.write(hdr[:]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *encoder) write(p []byte) error {
+	_, err := e.w.Write(p)
+	return err
+}
+
+// Apply rebuilds a file from the device's old copy, signed with blockSize,
+// and a delta read from r, writing it to w.
+func Apply(old io.ReaderAt, blockSize int, r io.Reader, w io.Writer) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var written int64
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: missing end", ErrCorrupt)
+		}
+		switch op {
+		case opCopy:
+			var hdr [8]byte
+			if _, err := io.ReadFull(br, hdr[:]); err != nil {
+				return fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+			start := int64(binary.BigEndian.Uint32(hdr[0:])) * int64(blockSize)
+			length := int64(binary.BigEndian.Uint32(hdr[4:])) * int64(blockSize)
+			n, err := io.Copy(w, io.NewSectionReader(old, start, length))
+			written += n
+			if err != nil {
+				return fmt.Errorf("copying blocks: %w", err)
+			}
+			if n == 0 {
+				return fmt.Errorf("%w: copy past end of file", ErrCorrupt)
+			}
+		case opLiteral:
+			var hdr [4]byte
+			if _, err := io.ReadFull(br, hdr[:]); err != nil {
+				return fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+			n, err := io.CopyN(w, br, int64(binary.BigEndian.Uint32(hdr[:])))
+			written += n
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+		case opEnd:
+			var hdr [8]byte
+			if _, err := io.ReadFull(br, hdr[:]); err != nil {
+				return fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+			if size := int64(binary.BigEndian.Uint64(hdr[:])); size != written {
+				return fmt.Errorf("%w: rebuilt %d bytes, expected %d", ErrCorrupt, written, size)
+			}
+			return nil
+		default:
+			return fmt.Errorf("%w: unknown opcode %q", ErrCorrupt, op)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// State is where a job is in its lifecycle.
+type State string
+
+const (
+	Queued  State = "queued"
+	Running State = "running"
+	Done    State = "done"
+	Failed  State = "failed"
+	Skipped State = "skipped" // nothing to do, e.g. a sync found the ROM present
+)
+
+// Job pushes one ROM to one device, either from the server library or, when
+// Source is set, from another device.
+type Job struct {
+	ID           int       `yaml:"id"`
+	Device       string    `yaml:"device"`
+	Console      string    `yaml:"console"` // server console directory
+	ROM          string    `yaml:"rom"`
+	Size         int64     `yaml:"size,omitempty"`          // bytes, when known before the job runs
+	Source       string    `yaml:"source,omitempty"`        // device to copy from instead of the server
+	SkipExisting bool      `yaml:"skip_existing,omitempty"` // sync: leave a ROM the device already has
+	State        State     `yaml:"state"`
+	Error        string    `yaml:"error,omitempty"`
+	Verified     string    `yaml:"verified,omitempty"` // how the copy was checked
+	Added        time.Time `yaml:"added"`
+	Finished     time.Time `yaml:"finished,omitempty"`
+}
+
+// Queue is an ordered list of jobs persisted to a YAML file. It is not safe
+// for concurrent use.
+type Queue struct {
+	path   string
+	jobs   []*Job
+	nextID int
+}
+
+type queueFile struct {
+	Jobs []*Job `yaml:"jobs"`
+}
+
+// Load reads the queue at path, returning an empty queue if the file does
+// not exist. Jobs left running by a previous session are queued again.
+func Load(path string) (*Queue, error) {
+	q := &Queue{path: path, nextID: 1}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("reading queue: %w", err)
+	}
+
+	var f queueFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing queue: %w", err)
+	}
+	for _, j := range f.Jobs {
+		if j.State == Running {
+			j.State = Queued
+		}
+		q.nextID = max(q.nextID, j.ID+1)
+	}
+	q.jobs = f.Jobs
+	return q, nil
+}
+
+// Save writes the queue to disk, replacing the previous file atomically.
+func (q *Queue) Save() error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o755); err != nil {
+		return fmt.Errorf("creating queue directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(queueFile{Jobs: q.jobs})
+	if err != nil {
+		return fmt.Errorf("marshaling queue: %w", err)
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing queue: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("writing queue: %w", err)
+	}
+	return nil
+}
+
+// Jobs returns a copy of every job in queue order.
+func (q *Queue) Jobs() []Job {
+	jobs := make([]Job, len(q.jobs))
+	for i, j := range q.jobs {
+		jobs[i] = *j
+	}
+	return jobs
+}
+
+// Add appends jobs in the Queued state and returns how many were added. A
+// job matching one already queued or running is dropped.
+func (q *Queue) Add(jobs ...Job) int {
+	added := 0
+	now := time.Now()
+	for _, j := range jobs {
+		if q.pending(j) {
+			continue
+		}
+		j.ID = q.nextID
+		q.nextID++
+		j.State = Queued
+		j.Error = ""
+		j.Verified = ""
+		j.Added = now
+		j.Finished = time.Time{}
+		q.jobs = append(q.jobs, &j)
+		added++
+	}
+	return added
+}
+
+// pending reports whether the same transfer is already queued or running.
+func (q *Queue) pending(j Job) bool {
+	for _, o := range q.jobs {
+		if (o.State == Queued || o.State == Running) &&
+			o.Device == j.Device && o.Console == j.Console && o.ROM == j.ROM && o.Source == j.Source {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) find(id int) int {
+	for i, j := range q.jobs {
+		if j.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the job with the given ID.
+func (q *Queue) Get(id int) (Job, bool) {
+	if i := q.find(id); i >= 0 {
+		return *q.jobs[i], true
+	}
+	return Job{}, false
+}
+
+// Next returns the first queued job for device.
+func (q *Queue) Next(device string) (Job, bool) {
+	for _, j := range q.jobs {
+		if j.Device == device && j.State == Queued {
+			return *j, true
+		}
+	}
+	return Job{}, false
+}
+
+// Devices returns every device with a queued job, in queue order.
+func (q *Queue) Devices() []string {
+	seen := make(map[string]bool)
+	var devices []string
+	for _, j := range q.jobs {
+		if j.State == Queued && !seen[j.Device] {
+			seen[j.Device] = true
+			devices = append(devices, j.Device)
+		}
+	}
+	return devices
+}
+
+// Start marks a job as running.
+func (q *Queue) Start(id int) {
+	if i := q.find(id); i >= 0 {
+		q.jobs[i].State = Running
+		q.jobs[i].Error = ""
+	}
+}
+
+// Finish records the outcome of a running job.
+func (q *Queue) Finish(id int, verified string, err error) {
+	i := q.find(id)
+	if i < 0 {
+		return
+	}
+	j := q.jobs[i]
+	j.Finished = time.Now()
+	j.Verified = verified
+	if err != nil {
+		j.State = Failed
+		j.Error = err.Error()
+	} else {
+		j.State = Done
+		j.Error = ""
+	}
+}
+
+// Skip records that a running job had nothing to do.
+func (q *Queue) Skip(id int, reason string) {
+	if i := q.find(id); i >= 0 {
+		q.jobs[i].State = Skipped
+		q.jobs[i].Error = reason
+		q.jobs[i].Finished = time.Now()
+	}
+}
+
+// Requeue puts a running job back in the queue, recording why it could not
+// run yet.
+func (q *Queue) Requeue(id int, reason string) {
+	if i := q.find(id); i >= 0 {
+		q.jobs[i].State = Queued
+		q.jobs[i].Error = reason
+	}
+}
+
+// Move shifts a job delta places within the queue, reporting whether it
+// moved.
+func (q *Queue) Move(id, delta int) bool {
+	i := q.find(id)
+	to := i + delta
+	if i < 0 || to < 0 || to >= len(q.jobs) {
+		return false
+	}
+	for i != to {
+		next := i + 1
+		if to < i {
+			next = i - 1
+		}
+		q.jobs[i], q.jobs[next] = q.jobs[next], q.jobs[i]
+		i = next
+	}
+	return true
+}
+
+// Remove deletes a job that is not running, reporting whether it did.
+func (q *Queue) Remove(id int) bool {
+	i := q.find(id)
+	if i < 0 || q.jobs[i].State == Running {
+		return false
+	}
+	q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+	return true
+}
+
+// Retry queues a failed job again, reporting whether it did.
+func (q *Queue) Retry(id int) bool {
+	i := q.find(id)
+	if i < 0 || q.jobs[i].State != Failed {
+		return false
+	}
+	q.jobs[i].State = Queued
+	q.jobs[i].Error = ""
+	q.jobs[i].Finished = time.Time{}
+	return true
+}
+
+// RetryFailed queues every failed job again and returns how many there were.
+func (q *Queue) RetryFailed() int {
+	n := 0
+	for _, j := range q.jobs {
+		if q.Retry(j.ID) {
+			n++
+		}
+	}
+	return n
+}
+
+// ClearDone removes done and skipped jobs and returns how many were removed.
+func (q *Queue) ClearDone() int {
+	kept := q.jobs[:0]
+	for _, j := range q.jobs {
+		if j.State != Done && j.State != Skipped {
+			kept = append(kept, j)
+		}
+	}
+	n := len(q.jobs) - len(kept)
+	clear(q.jobs[len(kept):])
+	q.jobs = kept
+	return n
+}
+
+// Count returns how many jobs are in the given state.
+func (q *Queue) Count(state State) int {
+	n := 0
+	for _, j := range q.jobs {
+		if j.State == state {
+			n++
+		}
+	}
+	return n
+}
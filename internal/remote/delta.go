@@ -0,0 +1,120 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"romrepo/internal/delta"
+)
+
+// ErrNoDelta is returned by PushDelta when the device has no delta helper.
+var ErrNoDelta = errors.New("delta helper is not available on the device")
+
+// DefaultDeltaHelper is the command run on a device to sign and rebuild
+// files for a delta transfer: romrepo itself, installed on the device.
+const DefaultDeltaHelper = "romrepo"
+
+// PushDelta brings remotePath, an older copy already on the device, up to
+// date with localPath by sending only the blocks that changed. helper signs
+// the device's copy and rebuilds the file into a partial file, which is
+// moved into place once complete. progress follows the scan of the local
+// file; the bytes actually sent are returned.
+func (s *shell) PushDelta(ctx context.Context, localPath, remotePath, helper string, progress ProgressFunc) (int64, error) {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+	info, err := localFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat local file: %w", err)
+	}
+	size := info.Size()
+
+	block := strconv.Itoa(delta.BlockSize(size))
+	out, err := s.output(helper + " delta sig " + block + " " + ShellQuote(remotePath))
+	if err != nil {
+		if errors.Is(err, errNoCommand) {
+			return 0, ErrNoDelta
+		}
+		return 0, fmt.Errorf("signing device copy: %w", err)
+	}
+	sig, err := delta.ParseSignature(strings.NewReader(out))
+	if err != nil {
+		return 0, err
+	}
+
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("opening SSH session: %w: %w", ErrConnectionLost, err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return 0, fmt.Errorf("opening delta input: %w", err)
+	}
+	part := ShellQuote(PartialPath(remotePath))
+	apply := fmt.Sprintf("%s delta apply %s %s %s", helper, block, ShellQuote(remotePath), part)
+	if perm := s.permCommand(s.perm.FileMode, part); perm != "" {
+		apply += " && " + perm
+	}
+	script := fmt.Sprintf("%s && mv -f %s %s; st=$?; [ $st -eq 0 ] || rm -f %s; exit $st",
+		apply, part, ShellQuote(remotePath), part)
+	if err := session.Start(script); err != nil {
+		return 0, fmt.Errorf("starting delta helper: %w", err)
+	}
+
+	w := &limitedWriter{ctx: ctx, w: stdin, limits: s.limits}
+	if progress != nil {
+		progress(0, size)
+	}
+	err = delta.Diff(ctx, localFile, sig, w, func(n int64) {
+		if progress != nil {
+			progress(n, size)
+		}
+	})
+	// Closing stdin early truncates the delta, so the helper fails and
+	// removes its partial file.
+	stdin.Close()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return w.n, ctxErr
+		}
+		if waitErr := session.Wait(); waitErr != nil {
+			return w.n, sessionError("delta apply", waitErr, &stderr)
+		}
+		return w.n, err
+	}
+	if err := session.Wait(); err != nil {
+		return w.n, sessionError("delta apply", err, &stderr)
+	}
+	return w.n, nil
+}
+
+// limitedWriter throttles and counts the data written through it.
+type limitedWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	limits []*RateLimiter
+	n      int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	for _, lim := range l.limits {
+		if err := lim.Wait(l.ctx, len(p)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,429 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+
+	"romrepo/internal/config"
+)
+
+// ftpTimeout bounds dialling and each control-connection exchange.
+const ftpTimeout = 15 * time.Second
+
+// FTPClient moves files for devices that expose FTP rather than SSH, such as
+// many MiSTer and handheld setups. A control connection carries one transfer
+// at a time, so ConnManager hands each transfer its own FTPClient.
+type FTPClient struct {
+	conn   *ftp.ServerConn
+	limits []*RateLimiter
+}
+
+// DialFTP connects and logs in to a client whose transport is "ftp" or, with
+// explicit TLS, "ftps". The "anonymous" auth method logs in without a
+// password.
+func DialFTP(client config.Client) (*FTPClient, error) {
+	port := client.Port
+	if port == 0 {
+		port = 21
+	}
+	addr := net.JoinHostPort(client.Host, strconv.Itoa(port))
+
+	opts := []ftp.DialOption{ftp.DialWithTimeout(ftpTimeout), ftp.DialWithShutTimeout(ftpTimeout)}
+	if client.Transport == "ftps" {
+		opts = append(opts, ftp.DialWithExplicitTLS(&tls.Config{ServerName: client.Host}))
+	}
+	conn, err := ftp.Dial(addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+
+	user, password := client.User, client.Auth.Password
+	switch client.Auth.Method {
+	case "anonymous":
+		user, password = "anonymous", "anonymous"
+	case "password":
+	default:
+		conn.Quit()
+		return nil, fmt.Errorf("unknown auth method for FTP: %s", client.Auth.Method)
+	}
+	if err := conn.Login(user, password); err != nil {
+		conn.Quit()
+		return nil, fmt.Errorf("logging in to %s: %w", addr, err)
+	}
+	return &FTPClient{conn: conn}, nil
+}
+
+func (f *FTPClient) Close() error {
+	return f.conn.Quit()
+}
+
+// alive reports whether the control connection still answers.
+func (f *FTPClient) alive() bool {
+	return f.conn.NoOp() == nil
+}
+
+// SetRateLimits throttles data written by this client's pushes and pulls to
+// the slowest of the given limiters.
+func (f *FTPClient) SetRateLimits(limits ...*RateLimiter) {
+	f.limits = limits
+}
+
+// SetPermissions does nothing: FTP offers no portable way to set modes or
+// groups, and config.Validate rejects them for FTP clients.
+func (f *FTPClient) SetPermissions(Permissions) {}
+
+// FixPermissions is not available over FTP.
+func (f *FTPClient) FixPermissions(dir string) error {
+	return fmt.Errorf("fixing permissions in %s: %w", dir, errors.ErrUnsupported)
+}
+
+func (f *FTPClient) ListFiles(dir string) ([]FileInfo, error) {
+	entries, err := f.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	for _, e := range entries {
+		if !e.IsDir && !IsPartial(e.Name) {
+			files = append(files, f.exact(e))
+		}
+	}
+	return files, nil
+}
+
+func (f *FTPClient) ListDir(dir string) ([]FileInfo, error) {
+	entries, err := f.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, len(entries))
+	for i, e := range entries {
+		files[i] = f.exact(e)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// list reads a directory, reporting a missing one with an error wrapping
+// os.ErrNotExist. Times come from the listing as is; see exact.
+func (f *FTPClient) list(dir string) ([]FileInfo, error) {
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, ftpError(err))
+	}
+	var files []FileInfo
+	for _, e := range entries {
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		files = append(files, FileInfo{
+			Name:    e.Name,
+			Size:    int64(e.Size),
+			IsDir:   e.Type == ftp.EntryTypeFolder,
+			ModTime: e.Time,
+		})
+	}
+	return files, nil
+}
+
+// exact clears a listed modification time unless the server lists with
+// MLSD. LIST output drops seconds, and the year for recent files, which is
+// close enough for cleaning up partial files but not for comparing copies.
+func (f *FTPClient) exact(info FileInfo) FileInfo {
+	if !f.conn.IsTimePreciseInList() {
+		info.ModTime = time.Time{}
+	}
+	return info
+}
+
+// ftpError maps a "file unavailable" reply to os.ErrNotExist, and a dropped
+// control or data connection to ErrConnectionLost.
+func ftpError(err error) error {
+	var tpErr *textproto.Error
+	var protoErr textproto.ProtocolError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &tpErr) && tpErr.Code == ftp.StatusFileUnavailable:
+		return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+	case errors.As(err, &tpErr) && tpErr.Code == ftp.StatusNotAvailable,
+		errors.As(err, &protoErr),
+		errors.As(err, &opErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed):
+		return fmt.Errorf("%w: %w", ErrConnectionLost, err)
+	}
+	return err
+}
+
+func (f *FTPClient) HomePath() (string, error) {
+	dir, err := f.conn.CurrentDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", ftpError(err))
+	}
+	return dir, nil
+}
+
+func (f *FTPClient) FileExists(p string) bool {
+	_, err := f.Stat(p)
+	return err == nil
+}
+
+// Stat finds p in a listing of its directory; MLST is not common enough on
+// device FTP servers to rely on.
+func (f *FTPClient) Stat(p string) (FileInfo, error) {
+	entries, err := f.list(path.Dir(p))
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %s: %w", p, err)
+	}
+	name := path.Base(p)
+	for _, e := range entries {
+		if e.Name == name {
+			return f.exact(e), nil
+		}
+	}
+	return FileInfo{}, fmt.Errorf("stat %s: %w", p, os.ErrNotExist)
+}
+
+func (f *FTPClient) Remove(p string) error {
+	if err := f.conn.Delete(p); err != nil {
+		return fmt.Errorf("removing %s: %w", p, ftpError(err))
+	}
+	return nil
+}
+
+// MkdirAll creates dir and any missing parents. FTP has no way to tell an
+// existing directory from a refused one, so errors are only reported when
+// dir is still missing at the end.
+func (f *FTPClient) MkdirAll(dir string) error {
+	f.mkdirs(dir)
+	if info, err := f.Stat(dir); err != nil || !info.IsDir {
+		return fmt.Errorf("creating %s: %w", dir, os.ErrNotExist)
+	}
+	return nil
+}
+
+// mkdirs tries to create dir and each of its parents, ignoring failures.
+func (f *FTPClient) mkdirs(dir string) {
+	var p string
+	for _, part := range strings.Split(path.Clean(dir), "/") {
+		if part == "" {
+			p = "/"
+			continue
+		}
+		p = path.Join(p, part)
+		f.conn.MakeDir(p)
+	}
+}
+
+// Chtimes sets the modification time with MFMT, or MDTM on servers that
+// accept it for writing, and fails with errors.ErrUnsupported elsewhere.
+func (f *FTPClient) Chtimes(p string, mtime time.Time) error {
+	if !f.conn.IsSetTimeSupported() {
+		return fmt.Errorf("setting times on %s: %w", p, errors.ErrUnsupported)
+	}
+	if err := f.conn.SetTime(p, mtime); err != nil {
+		return fmt.Errorf("setting times on %s: %w", p, ftpError(err))
+	}
+	return nil
+}
+
+// FreeSpace is not available over FTP.
+func (f *FTPClient) FreeSpace(p string) (uint64, error) {
+	return 0, fmt.Errorf("checking free space on %s: %w", p, errors.ErrUnsupported)
+}
+
+func (f *FTPClient) Push(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	return f.PushReader(ctx, localFile, info.Size(), remotePath, progress)
+}
+
+// Resume is a full Push: device FTP servers differ too much in how they
+// handle restarted uploads to trust one, so partial files are never kept.
+func (f *FTPClient) Resume(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	return f.Push(ctx, localPath, remotePath, progress)
+}
+
+// PushReader stores size bytes from r in a hidden partial file, checks its
+// size and renames it over remotePath. A failed upload removes the partial
+// file; one that is complete but cannot be renamed is kept, as it may be
+// the only copy left.
+func (f *FTPClient) PushReader(ctx context.Context, r io.Reader, size int64, remotePath string, progress ProgressFunc) error {
+	f.mkdirs(path.Dir(remotePath))
+
+	partPath := PartialPath(remotePath)
+	if err := f.store(ctx, r, size, partPath, progress); err != nil {
+		f.conn.Delete(partPath)
+		return err
+	}
+	return f.rename(partPath, remotePath)
+}
+
+func (f *FTPClient) store(ctx context.Context, r io.Reader, size int64, partPath string, progress ProgressFunc) error {
+	if progress != nil {
+		progress(0, size)
+	}
+	pr := &progressReader{ctx: ctx, r: io.LimitReader(r, size), total: size, progress: progress, limits: f.limits}
+	if err := f.conn.Stor(partPath, pr); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("write error: %w", ftpError(err))
+	}
+	if pr.read != size {
+		return fmt.Errorf("read %d bytes, expected %d", pr.read, size)
+	}
+
+	n, err := f.conn.FileSize(partPath)
+	if err != nil {
+		// SIZE is an extension; fall back to a listing.
+		info, statErr := f.Stat(partPath)
+		if statErr != nil {
+			return fmt.Errorf("stat remote file: %w", err)
+		}
+		n = info.Size
+	}
+	if n != size {
+		return fmt.Errorf("remote file is %d bytes, expected %d", n, size)
+	}
+	return nil
+}
+
+// rename moves oldPath over newPath. Servers differ on whether RNTO
+// replaces an existing file; when one refuses, the target is removed and
+// the rename tried again.
+func (f *FTPClient) rename(oldPath, newPath string) error {
+	err := f.conn.Rename(oldPath, newPath)
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 && f.FileExists(newPath) {
+		if err := f.conn.Delete(newPath); err != nil {
+			return fmt.Errorf("replacing %s: %w", path.Base(newPath), ftpError(err))
+		}
+		err = f.conn.Rename(oldPath, newPath)
+	}
+	if err != nil {
+		return fmt.Errorf("renaming %s: %w", path.Base(oldPath), ftpError(err))
+	}
+	return nil
+}
+
+// Open streams a remote file, returning it with its size. The control
+// connection is busy until the reader is closed.
+func (f *FTPClient) Open(p string) (io.ReadCloser, int64, error) {
+	size, err := f.conn.FileSize(p)
+	if err != nil {
+		info, statErr := f.Stat(p)
+		if statErr != nil {
+			return nil, 0, fmt.Errorf("opening remote file: %w", statErr)
+		}
+		size = info.Size
+	}
+	resp, err := f.conn.Retr(p)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening remote file: %w", ftpError(err))
+	}
+	return resp, size, nil
+}
+
+func (f *FTPClient) Pull(ctx context.Context, remotePath, localPath string, progress ProgressFunc) error {
+	src, size, err := f.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return fmt.Errorf("creating local directory: %w", err)
+	}
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("creating local file: %w", err)
+	}
+	defer dst.Close()
+
+	if err := copyWithProgress(ctx, src, dst, size, progress, f.limits); err != nil {
+		return ftpError(err)
+	}
+	// A data connection that drops can look like the end of the file; the
+	// server's final reply and the byte count tell them apart.
+	if err := src.Close(); err != nil {
+		return fmt.Errorf("reading %s: %w", remotePath, ftpError(err))
+	}
+	if n, err := dst.Seek(0, io.SeekCurrent); err == nil && n != size {
+		return fmt.Errorf("reading %s: got %d of %d bytes: %w", remotePath, n, size, ErrConnectionLost)
+	}
+	return nil
+}
+
+// CleanupPartials removes partial files in dir untouched for longer than
+// maxAge. Servers that hide dot files from listings keep theirs, but
+// PushReader never leaves one behind on those.
+func (f *FTPClient) CleanupPartials(dir string, maxAge time.Duration) error {
+	entries, err := f.list(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		if e.IsDir || !IsPartial(e.Name) || e.ModTime.After(cutoff) {
+			continue
+		}
+		if err := f.Remove(path.Join(dir, e.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checksum hashes a remote file by reading it back, as FTP runs no commands
+// on the device. Without readBack it fails with an error wrapping
+// ErrNoChecksumCommand.
+func (f *FTPClient) Checksum(p, algo string, readBack bool) (Checksum, error) {
+	if !readBack {
+		return Checksum{}, fmt.Errorf("%w over FTP", ErrNoChecksumCommand)
+	}
+	if algo == "" {
+		algo = checksumAlgos[0].name
+	}
+	r, _, err := f.Open(p)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer r.Close()
+	sum, err := hashReader(r, algo)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("reading back %s: %w", p, ftpError(err))
+	}
+	return Checksum{Algo: algo, Sum: sum, Method: VerifyReadBack}, nil
+}
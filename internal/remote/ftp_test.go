@@ -0,0 +1,428 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"romrepo/internal/config"
+)
+
+func TestFTPClient(t *testing.T) {
+	for _, mlsd := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mlsd=%t", mlsd), func(t *testing.T) {
+			testFTPClient(t, mlsd)
+		})
+	}
+}
+
+func testFTPClient(t *testing.T, mlsd bool) {
+	stub := startFTPStub(t, mlsd, "")
+	f := dialFTPStub(t, stub)
+	ctx := context.Background()
+
+	// PushReader creates the directory and leaves no partial file.
+	rom := "/snes/Game (USA).sfc"
+	data := bytes.Repeat([]byte("snes"), 10000)
+	if err := f.PushReader(ctx, bytes.NewReader(data), int64(len(data)), rom, nil); err != nil {
+		t.Fatalf("PushReader: %v", err)
+	}
+	if got, err := os.ReadFile(stub.local(rom)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("pushed file = %d bytes, %v; want %d bytes", len(got), err, len(data))
+	}
+	if _, err := os.Stat(stub.local(PartialPath(rom))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+
+	// Pull brings the same bytes back, reporting progress up to the size.
+	pulled := filepath.Join(t.TempDir(), "pulled", "Game (USA).sfc")
+	var last int64
+	if err := f.Pull(ctx, rom, pulled, func(n, total int64) { last = n }); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got, err := os.ReadFile(pulled); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("pulled file = %d bytes, %v; want %d bytes", len(got), err, len(data))
+	}
+	if last != int64(len(data)) {
+		t.Fatalf("Pull progress ended at %d, want %d", last, len(data))
+	}
+	if err := f.Pull(ctx, "/snes/Missing.sfc", pulled, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Pull missing file: %v, want os.ErrNotExist", err)
+	}
+
+	// ListFiles skips directories and partial files.
+	os.WriteFile(stub.local(PartialPath("/snes/Other.sfc")), []byte("half"), 0o644)
+	os.Mkdir(stub.local("/snes/saves"), 0o755)
+	files, err := f.ListFiles("/snes")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "Game (USA).sfc" || files[0].Size != int64(len(data)) {
+		t.Fatalf("ListFiles = %+v, want only Game (USA).sfc", files)
+	}
+	if files[0].ModTime.IsZero() == mlsd {
+		t.Fatalf("ModTime = %v with mlsd %t; only MLSD times are precise", files[0].ModTime, mlsd)
+	}
+
+	info, err := f.Stat(rom)
+	if err != nil || info.Size != int64(len(data)) || info.IsDir {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+	if _, err := f.Stat("/snes/Missing.sfc"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat missing file: %v, want os.ErrNotExist", err)
+	}
+
+	// A second push replaces the file, though the stub will not rename a
+	// partial file over it.
+	update := []byte("patched")
+	if err := f.PushReader(ctx, bytes.NewReader(update), int64(len(update)), rom, nil); err != nil {
+		t.Fatalf("PushReader over existing file: %v", err)
+	}
+	if got, _ := os.ReadFile(stub.local(rom)); !bytes.Equal(got, update) {
+		t.Fatalf("replaced file = %q, want %q", got, update)
+	}
+
+	// A short push fails, removes its partial file and keeps the old ROM.
+	if err := f.PushReader(ctx, bytes.NewReader([]byte("short")), 100, rom, nil); err == nil {
+		t.Fatal("PushReader with a short reader succeeded")
+	}
+	if _, err := os.Stat(stub.local(PartialPath(rom))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left after failed push: %v", err)
+	}
+	if got, _ := os.ReadFile(stub.local(rom)); !bytes.Equal(got, update) {
+		t.Fatalf("failed push changed the ROM to %q", got)
+	}
+
+	if mlsd {
+		mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		if err := f.Chtimes(rom, mtime); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+		if info, err := f.Stat(rom); err != nil || !info.ModTime.Equal(mtime) {
+			t.Fatalf("ModTime after Chtimes = %v, %v; want %v", info.ModTime, err, mtime)
+		}
+	}
+
+	if err := f.Remove(rom); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if f.FileExists(rom) {
+		t.Fatal("file still exists after Remove")
+	}
+	if err := f.Remove(rom); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Remove missing file: %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestFTPResume(t *testing.T) {
+	stub := startFTPStub(t, true, "")
+	f := dialFTPStub(t, stub)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("gba"), 5000)
+	local := filepath.Join(t.TempDir(), "Game.gba")
+	os.WriteFile(local, data, 0o644)
+
+	// A partial file from an earlier attempt is replaced, never appended to.
+	rom := "/gba/Game.gba"
+	os.Mkdir(stub.local("/gba"), 0o755)
+	os.WriteFile(stub.local(PartialPath(rom)), []byte("stale bytes from another version"), 0o644)
+	if err := f.Resume(ctx, local, rom, nil); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if got, _ := os.ReadFile(stub.local(rom)); !bytes.Equal(got, data) {
+		t.Fatalf("resumed file = %d bytes, want %d", len(got), len(data))
+	}
+	if _, err := os.Stat(stub.local(PartialPath(rom))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left after Resume: %v", err)
+	}
+
+	// CleanupPartials removes only partial files older than maxAge.
+	old, fresh := PartialPath("/gba/Old.gba"), PartialPath("/gba/Fresh.gba")
+	os.WriteFile(stub.local(old), []byte("old"), 0o644)
+	os.WriteFile(stub.local(fresh), []byte("fresh"), 0o644)
+	stale := time.Now().Add(-2 * PartialMaxAge)
+	os.Chtimes(stub.local(old), stale, stale)
+	if err := f.CleanupPartials("/gba", PartialMaxAge); err != nil {
+		t.Fatalf("CleanupPartials: %v", err)
+	}
+	if _, err := os.Stat(stub.local(old)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("old partial file kept: %v", err)
+	}
+	if _, err := os.Stat(stub.local(fresh)); err != nil {
+		t.Fatalf("fresh partial file removed: %v", err)
+	}
+	if _, err := os.Stat(stub.local(rom)); err != nil {
+		t.Fatalf("CleanupPartials removed the ROM: %v", err)
+	}
+}
+
+func TestFTPConnectionLost(t *testing.T) {
+	for _, cmd := range []string{"STOR", "RETR"} {
+		t.Run(cmd, func(t *testing.T) {
+			stub := startFTPStub(t, false, cmd)
+			os.WriteFile(stub.local("/rom.sfc"), []byte("on the device"), 0o644)
+			f := dialFTPStub(t, stub)
+
+			var err error
+			if cmd == "STOR" {
+				err = f.PushReader(context.Background(), strings.NewReader("rom"), 3, "/rom.sfc", nil)
+			} else {
+				err = f.Pull(context.Background(), "/rom.sfc", filepath.Join(t.TempDir(), "rom.sfc"), nil)
+			}
+			if !IsConnectionLost(err) {
+				t.Fatalf("error when the server drops on %s = %v, want a lost connection", cmd, err)
+			}
+		})
+	}
+}
+
+func dialFTPStub(t *testing.T, stub *ftpStub) *FTPClient {
+	f, err := DialFTP(config.Client{
+		Transport: "ftp",
+		Host:      "127.0.0.1",
+		Port:      stub.port(),
+		User:      "mister",
+		Auth:      config.AuthConfig{Method: "password", Password: "1234"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// ftpStub is a minimal FTP server over a temporary directory, speaking just
+// the commands FTPClient sends. With mlsd it advertises MLST, so listings
+// use MLSD and carry exact times. A connection that sends the dropOn
+// command is closed without a reply.
+type ftpStub struct {
+	root   string
+	mlsd   bool
+	dropOn string
+	ln     net.Listener
+}
+
+func startFTPStub(t *testing.T, mlsd bool, dropOn string) *ftpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ftpStub{root: t.TempDir(), mlsd: mlsd, dropOn: dropOn, ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ftpStub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// local maps an FTP path to the file under the stub's root.
+func (s *ftpStub) local(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (s *ftpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) { tp.PrintfLine(format, args...) }
+
+	var pasv net.Listener
+	defer func() {
+		if pasv != nil {
+			pasv.Close()
+		}
+	}()
+	// data accepts the client's connection to the last EPSV port.
+	data := func() (net.Conn, error) {
+		if pasv == nil {
+			return nil, errors.New("no EPSV")
+		}
+		defer func() { pasv.Close(); pasv = nil }()
+		return pasv.Accept()
+	}
+
+	var renameFrom string
+	reply("220 stub ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		if strings.EqualFold(cmd, s.dropOn) {
+			return
+		}
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			if arg == "1234" {
+				reply("230 logged in")
+			} else {
+				reply("530 wrong password")
+			}
+		case "FEAT":
+			reply("211-Features:")
+			reply(" SIZE")
+			reply(" MFMT")
+			if s.mlsd {
+				reply(" MLST type*;size*;modify*;")
+			}
+			reply("211 End")
+		case "TYPE", "OPTS", "NOOP":
+			reply("200 ok")
+		case "PWD":
+			reply(`257 "/" is the current directory`)
+		case "EPSV":
+			pasv, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", pasv.Addr().(*net.TCPAddr).Port)
+		case "LIST", "MLSD":
+			entries, err := os.ReadDir(s.local(arg))
+			if err != nil {
+				reply("550 %v", err)
+				continue
+			}
+			dc, err := data()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			reply("150 listing")
+			for _, e := range entries {
+				info, err := e.Info()
+				if err != nil {
+					continue
+				}
+				if cmd == "MLSD" {
+					kind := "file"
+					if e.IsDir() {
+						kind = "dir"
+					}
+					fmt.Fprintf(dc, "type=%s;size=%d;modify=%s; %s\r\n",
+						kind, info.Size(), info.ModTime().UTC().Format("20060102150405"), e.Name())
+				} else {
+					mode := "-rw-r--r--"
+					if e.IsDir() {
+						mode = "drwxr-xr-x"
+					}
+					fmt.Fprintf(dc, "%s 1 root root %d %s %s\r\n",
+						mode, info.Size(), info.ModTime().Format("Jan _2 15:04"), e.Name())
+				}
+			}
+			dc.Close()
+			reply("226 done")
+		case "STOR":
+			dc, err := data()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			reply("150 send it")
+			f, err := os.Create(s.local(arg))
+			if err != nil {
+				dc.Close()
+				reply("550 %v", err)
+				continue
+			}
+			_, err = io.Copy(f, dc)
+			f.Close()
+			dc.Close()
+			if err != nil {
+				reply("451 %v", err)
+				continue
+			}
+			reply("226 stored")
+		case "RETR":
+			f, err := os.Open(s.local(arg))
+			if err != nil {
+				reply("550 %v", err)
+				continue
+			}
+			dc, err := data()
+			if err != nil {
+				f.Close()
+				reply("425 %v", err)
+				continue
+			}
+			reply("150 sending")
+			io.Copy(dc, f)
+			f.Close()
+			dc.Close()
+			reply("226 sent")
+		case "SIZE":
+			if info, err := os.Stat(s.local(arg)); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("213 %d", info.Size())
+			}
+		case "MFMT":
+			stamp, p, _ := strings.Cut(arg, " ")
+			mtime, err := time.Parse("20060102150405", stamp)
+			if err == nil {
+				err = os.Chtimes(s.local(p), mtime, mtime)
+			}
+			if err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("213 Modify=%s; %s", stamp, p)
+			}
+		case "DELE":
+			if err := os.Remove(s.local(arg)); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("250 deleted")
+			}
+		case "MKD":
+			if err := os.Mkdir(s.local(arg), 0o755); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply(`257 "%s" created`, arg)
+			}
+		case "RNFR":
+			if _, err := os.Stat(s.local(arg)); err != nil {
+				reply("550 %v", err)
+			} else {
+				renameFrom = arg
+				reply("350 ready for RNTO")
+			}
+		case "RNTO":
+			// Like many device servers, refuse to rename over a file.
+			if _, err := os.Stat(s.local(arg)); err == nil {
+				reply("553 %s: file exists", arg)
+			} else if err := os.Rename(s.local(renameFrom), s.local(arg)); err != nil {
+				reply("550 %v", err)
+			} else {
+				reply("250 renamed")
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 %s not implemented", cmd)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LocalClient moves ROMs to a directory on this machine, such as an SD card
+// mounted at /media/sdcard or a USB drive. Pushes go through the same hidden
+// partial files as the SSH transports so a pulled card never holds a
+// truncated ROM.
+type LocalClient struct {
+	limits []*RateLimiter
+	perm   Permissions
+}
+
+func NewLocalClient() *LocalClient {
+	return &LocalClient{}
+}
+
+// Close does nothing; LocalClient holds no resources.
+func (l *LocalClient) Close() error {
+	return nil
+}
+
+// SetRateLimits throttles data written by this client's pushes and pulls to
+// the slowest of the given limiters.
+func (l *LocalClient) SetRateLimits(limits ...*RateLimiter) {
+	l.limits = limits
+}
+
+// SetPermissions sets the mode and group given to files and directories
+// this client creates.
+func (l *LocalClient) SetPermissions(p Permissions) {
+	l.perm = p
+}
+
+func (l *LocalClient) ListFiles(dir string) ([]FileInfo, error) {
+	entries, err := l.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	for _, e := range entries {
+		if !e.IsDir && !IsPartial(e.Name) {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func (l *LocalClient) ListDir(dir string) ([]FileInfo, error) {
+	entries, err := l.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func (l *LocalClient) list(dir string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, err)
+	}
+	files := make([]FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: e.Name(), Size: info.Size(), IsDir: e.IsDir(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (l *LocalClient) HomePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return home, nil
+}
+
+func (l *LocalClient) FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (l *LocalClient) Stat(path string) (FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return FileInfo{Name: info.Name(), Size: info.Size(), IsDir: info.IsDir(), ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalClient) Remove(path string) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("removing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (l *LocalClient) MkdirAll(dir string) error {
+	if err := l.mkdirAll(dir); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents, giving the directories it
+// creates the configured mode and group.
+func (l *LocalClient) mkdirAll(dir string) error {
+	if !l.perm.dirs() {
+		return os.MkdirAll(dir, 0o755)
+	}
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := l.applyPermissions(missing[i], l.perm.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPermissions gives path mode, unless it is zero, and the configured
+// group.
+func (l *LocalClient) applyPermissions(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("setting mode on %s: %w", path, err)
+		}
+	}
+	if l.perm.Group == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(l.perm.Group)
+	if err != nil {
+		g, lookupErr := user.LookupGroup(l.perm.Group)
+		if lookupErr != nil {
+			return fmt.Errorf("looking up group %s: %w", l.perm.Group, lookupErr)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if err := os.Lchown(path, -1, gid); err != nil {
+		return fmt.Errorf("setting group on %s: %w", path, err)
+	}
+	return nil
+}
+
+// FixPermissions applies the configured modes and group to dir and
+// everything below it.
+func (l *LocalClient) FixPermissions(dir string) error {
+	if l.perm.IsZero() {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("fixing permissions in %s: %w", dir, err)
+		}
+		mode := l.perm.FileMode
+		switch {
+		case d.IsDir():
+			mode = l.perm.DirMode
+		case !d.Type().IsRegular():
+			return nil
+		}
+		return l.applyPermissions(path, mode)
+	})
+}
+
+func (l *LocalClient) Chtimes(path string, mtime time.Time) error {
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		return fmt.Errorf("setting times on %s: %w", path, err)
+	}
+	return nil
+}
+
+// FreeSpace returns the bytes available to this user on the filesystem
+// holding path.
+func (l *LocalClient) FreeSpace(path string) (uint64, error) {
+	free, err := diskFree(path)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space on %s: %w", path, err)
+	}
+	return free, nil
+}
+
+func (l *LocalClient) Push(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	return l.PushReader(ctx, localFile, info.Size(), remotePath, progress)
+}
+
+// PushReader writes size bytes from r to a hidden partial file next to
+// remotePath, syncing it to the card before renaming it into place.
+// Cancelling ctx removes the partial file; other errors leave it for Resume.
+func (l *LocalClient) PushReader(ctx context.Context, r io.Reader, size int64, remotePath string, progress ProgressFunc) error {
+	if err := l.mkdirAll(filepath.Dir(remotePath)); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	partPath := PartialPath(remotePath)
+	f, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	defer f.Close()
+
+	if err := copyWithProgress(ctx, r, f, size, progress, l.limits); err != nil {
+		return l.abortPartial(f, partPath, err)
+	}
+	return l.finishPartial(f, partPath, remotePath, size)
+}
+
+// Resume continues an interrupted push from the end of its partial file
+// once the data already written has been checked against localPath. A
+// partial file that does not match is replaced by a full push.
+func (l *LocalClient) Resume(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	totalSize := info.Size()
+
+	partPath := PartialPath(remotePath)
+	partInfo, err := os.Stat(partPath)
+	if err != nil || partInfo.Size() == 0 || partInfo.Size() > totalSize {
+		return l.PushReader(ctx, localFile, totalSize, remotePath, progress)
+	}
+	offset := partInfo.Size()
+
+	f, err := os.OpenFile(partPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	if err := verifyPrefix(localFile, f, offset); err != nil {
+		if !errors.Is(err, ErrPrefixMismatch) {
+			return err
+		}
+		// A partial file from another version of the ROM is started over.
+		f.Close()
+		os.Remove(partPath)
+		if _, err := localFile.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking local file: %w", err)
+		}
+		return l.PushReader(ctx, localFile, totalSize, remotePath, progress)
+	}
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking local file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking file: %w", err)
+	}
+
+	resumed := func(transferred, total int64) {
+		if progress != nil {
+			progress(offset+transferred, totalSize)
+		}
+	}
+	if err := copyWithProgress(ctx, localFile, f, totalSize-offset, resumed, l.limits); err != nil {
+		return l.abortPartial(f, partPath, err)
+	}
+	return l.finishPartial(f, partPath, remotePath, totalSize)
+}
+
+// finishPartial syncs and closes a completed partial file, checks its size
+// and renames it over remotePath. Removable media caches writes
+// aggressively, so the sync is what makes the ROM survive the card being
+// pulled.
+func (l *LocalClient) finishPartial(f *os.File, partPath, remotePath string, size int64) error {
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing file: %w", err)
+	}
+
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("file is %d bytes, expected %d", info.Size(), size)
+	}
+	if err := l.applyPermissions(partPath, l.perm.FileMode); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, remotePath); err != nil {
+		return fmt.Errorf("renaming %s: %w", filepath.Base(partPath), err)
+	}
+	return nil
+}
+
+func (l *LocalClient) abortPartial(f *os.File, partPath string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		f.Close()
+		os.Remove(partPath)
+	}
+	return err
+}
+
+func (l *LocalClient) Pull(ctx context.Context, remotePath, localPath string, progress ProgressFunc) error {
+	src, size, err := l.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return fmt.Errorf("creating local directory: %w", err)
+	}
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("creating local file: %w", err)
+	}
+	defer dst.Close()
+
+	return copyWithProgress(ctx, src, dst, size, progress, l.limits)
+}
+
+func (l *LocalClient) Open(path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat file: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+// CleanupPartials removes partial files in dir untouched for longer than
+// maxAge.
+func (l *LocalClient) CleanupPartials(dir string, maxAge time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("listing %s: %w", dir, err)
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		if e.IsDir() || !IsPartial(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("removing %s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Checksum hashes a file by reading it back from the card. Without readBack
+// it fails with an error wrapping ErrNoChecksumCommand, as there is no
+// device command to ask.
+func (l *LocalClient) Checksum(path, algo string, readBack bool) (Checksum, error) {
+	if !readBack {
+		return Checksum{}, fmt.Errorf("%w for a local target", ErrNoChecksumCommand)
+	}
+	if algo == "" {
+		algo = checksumAlgos[0].name
+	}
+	sum, err := LocalChecksum(path, algo)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("reading back %s: %w", path, err)
+	}
+	return Checksum{Algo: algo, Sum: sum, Method: VerifyReadBack}, nil
+}
@@ -0,0 +1,65 @@
+package remote
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"romrepo/internal/config"
+)
+
+// Permissions are given to the files and directories a transport creates,
+// for devices where the frontend runs as a different user than the one
+// pushing ROMs. Zero modes keep the device's defaults and an empty Group
+// leaves ownership alone.
+type Permissions struct {
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	Group    string // name or numeric ID
+}
+
+// PermissionsOf returns the permissions configured for a client. Modes that
+// do not parse are ignored; config.Validate reports them on load.
+func PermissionsOf(client config.Client) Permissions {
+	fileMode, _ := config.ParseMode(client.FileMode)
+	dirMode, _ := config.ParseMode(client.DirMode)
+	return Permissions{FileMode: fileMode, DirMode: dirMode, Group: client.Group}
+}
+
+// IsZero reports whether no permissions are configured.
+func (p Permissions) IsZero() bool {
+	return p.FileMode == 0 && p.DirMode == 0 && p.Group == ""
+}
+
+// dirs reports whether anything is set for new directories.
+func (p Permissions) dirs() bool {
+	return p.DirMode != 0 || p.Group != ""
+}
+
+// lookupGroup finds a group's ID in the /etc/group file returned by open.
+// A numeric name is taken as the ID itself.
+func lookupGroup(name string, open func() (io.ReadCloser, error)) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	f, err := open()
+	if err != nil {
+		return 0, fmt.Errorf("looking up group %s: %w", name, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("looking up group %s: %w", name, err)
+	}
+	return 0, fmt.Errorf("unknown group %q", name)
+}
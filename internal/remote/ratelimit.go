@@ -0,0 +1,67 @@
+package remote
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter caps throughput with a token bucket holding up to one second
+// of data. The rate can be changed while transfers are waiting on it.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second; 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing bps bytes per second, or no
+// limit when bps is 0.
+func NewRateLimiter(bps int64) *RateLimiter {
+	return &RateLimiter{rate: bps, last: time.Now()}
+}
+
+// SetRate changes the limit, taking effect for the next chunk.
+func (l *RateLimiter) SetRate(bps int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = bps
+	l.tokens = min(l.tokens, float64(bps))
+}
+
+// Rate returns the limit in bytes per second, 0 when unlimited.
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait blocks until n more bytes may be sent or ctx is cancelled. A chunk
+// larger than the bucket is let through and paid for by waiting longer
+// before the next one.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+		l.last = now
+		if l.tokens >= 0 {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		// Sleep in short steps so a raised limit is picked up promptly.
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(wait, 100*time.Millisecond)):
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SCPClient moves files for devices whose SSH server has no SFTP subsystem,
+// such as Dropbear builds without sftp-server. Data goes over the scp
+// protocol and everything else is done with shell commands, each in its own
+// exec session.
+type SCPClient struct {
+	shell
+}
+
+func NewSCPClient(sshConn *ssh.Client) *SCPClient {
+	return &SCPClient{shell{conn: sshConn}}
+}
+
+// Close does nothing; SCPClient holds no session between calls.
+func (s *SCPClient) Close() error {
+	return nil
+}
+
+func (s *SCPClient) ListFiles(dir string) ([]FileInfo, error) {
+	entries, err := s.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	for _, e := range entries {
+		if !e.IsDir && !IsPartial(e.Name) {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+func (s *SCPClient) ListDir(dir string) ([]FileInfo, error) {
+	entries, err := s.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// list reads a directory with find -printf, falling back to parsing ls -l
+// on devices whose find lacks it, such as BusyBox.
+func (s *SCPClient) list(dir string) ([]FileInfo, error) {
+	out, err := s.output("find " + ShellQuote(dir) + ` -mindepth 1 -maxdepth 1 -printf '%y\t%s\t%T@\t%f\n'`)
+	if err == nil {
+		return parseFindOutput(out), nil
+	}
+	out, err = s.output("LC_ALL=C ls -lAn " + ShellQuote(dir))
+	if err == nil {
+		return parseLsOutput(out), nil
+	}
+	if s.run("test -d "+ShellQuote(dir)) != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, os.ErrNotExist)
+	}
+	return nil, fmt.Errorf("listing %s: %w", dir, err)
+}
+
+// parseFindOutput reads "type<TAB>size<TAB>mtime<TAB>name" lines, the
+// modification time in fractional seconds since the epoch.
+func parseFindOutput(out string) []FileInfo {
+	var entries []FileInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		info := FileInfo{Name: fields[3], Size: size, IsDir: fields[0] == "d"}
+		if secs, err := strconv.ParseFloat(fields[2], 64); err == nil {
+			info.ModTime = time.Unix(0, int64(secs*1e9))
+		}
+		entries = append(entries, info)
+	}
+	return entries
+}
+
+// parseLsOutput reads ls -ln lines: mode, links, owner, group, size, three
+// date fields and the name. Devices, sockets and the like are skipped. The
+// dates are too coarse to use, so ModTime is left zero.
+func parseLsOutput(out string) []FileInfo {
+	var entries []FileInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields, name := splitFields(line, 8)
+		if len(fields) < 8 || name == "" {
+			continue
+		}
+		var isDir bool
+		switch fields[0][0] {
+		case 'd':
+			isDir = true
+		case 'l':
+			name, _, _ = strings.Cut(name, " -> ")
+		case '-':
+		default:
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[4], 10, 64)
+		entries = append(entries, FileInfo{Name: name, Size: size, IsDir: isDir})
+	}
+	return entries
+}
+
+// splitFields returns the first n space-separated fields of line and the
+// rest of it, which keeps any spaces inside a file name.
+func splitFields(line string, n int) ([]string, string) {
+	var fields []string
+	rest := line
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			break
+		}
+		field, tail, _ := strings.Cut(rest, " ")
+		fields = append(fields, field)
+		rest = tail
+	}
+	return fields, strings.TrimLeft(rest, " ")
+}
+
+func (s *SCPClient) HomePath() (string, error) {
+	out, err := s.output("pwd")
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (s *SCPClient) FileExists(path string) bool {
+	return s.run("test -e "+ShellQuote(path)) == nil
+}
+
+// Stat finds path in a listing of its directory, so it works wherever list
+// does.
+func (s *SCPClient) Stat(path string) (FileInfo, error) {
+	entries, err := s.list(filepath.Dir(path))
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	name := filepath.Base(path)
+	for _, e := range entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return FileInfo{}, fmt.Errorf("stat %s: %w", path, os.ErrNotExist)
+}
+
+func (s *SCPClient) Remove(path string) error {
+	if _, err := s.output("rm -- " + ShellQuote(path)); err != nil {
+		return fmt.Errorf("removing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *SCPClient) MkdirAll(dir string) error {
+	if _, err := s.output(s.mkdirCommand(dir)); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return nil
+}
+
+// FixPermissions applies the configured modes and group to dir and
+// everything below it.
+func (s *SCPClient) FixPermissions(dir string) error {
+	return s.fixPermissions(dir)
+}
+
+// Chtimes sets both times with touch -t, which takes whole seconds in the
+// device's local time; TZ pins that to UTC.
+func (s *SCPClient) Chtimes(path string, mtime time.Time) error {
+	stamp := mtime.UTC().Format("200601021504.05")
+	if _, err := s.output("TZ=UTC0 touch -c -t " + stamp + " -- " + ShellQuote(path)); err != nil {
+		return fmt.Errorf("setting times on %s: %w", path, err)
+	}
+	return nil
+}
+
+// FreeSpace returns the bytes available on the filesystem holding path.
+func (s *SCPClient) FreeSpace(path string) (uint64, error) {
+	return s.freeSpace(path)
+}
+
+func (s *SCPClient) Push(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	return s.PushReader(ctx, localFile, info.Size(), remotePath, progress)
+}
+
+// Resume is a full Push: scp cannot append to a file, so partial files are
+// never kept.
+func (s *SCPClient) Resume(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	return s.Push(ctx, localPath, remotePath, progress)
+}
+
+// PushReader sends size bytes from r with "scp -t" into a hidden partial
+// file, moving it over remotePath once the device has acknowledged all of
+// it. A failed push removes the partial file.
+func (s *SCPClient) PushReader(ctx context.Context, r io.Reader, size int64, remotePath string, progress ProgressFunc) error {
+	partPath := PartialPath(remotePath)
+	err := s.scpSend(ctx, r, size, partPath, progress)
+	if err == nil {
+		mv := "mv -f " + ShellQuote(partPath) + " " + ShellQuote(remotePath)
+		if perm := s.permCommand(s.perm.FileMode, ShellQuote(partPath)); perm != "" {
+			mv = perm + " && " + mv
+		}
+		err = s.run(mv)
+		if err != nil {
+			err = fmt.Errorf("renaming %s: %w", filepath.Base(partPath), err)
+		}
+	}
+	if err != nil {
+		s.run("rm -f " + ShellQuote(partPath))
+	}
+	return err
+}
+
+// scpSend runs the sending side of the scp protocol against "scp -t".
+func (s *SCPClient) scpSend(ctx context.Context, r io.Reader, size int64, path string, progress ProgressFunc) error {
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("opening SSH session: %w: %w", ErrConnectionLost, err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("opening scp input: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("opening scp output: %w", err)
+	}
+	acks := bufio.NewReader(stdout)
+
+	command := s.mkdirCommand(filepath.Dir(path)) + " && scp -t " + ShellQuote(path)
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("starting scp: %w", err)
+	}
+	fail := func(err error) error {
+		stdin.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, io.EOF) {
+			// The command ended early; its exit status says why.
+			if waitErr := session.Wait(); waitErr != nil {
+				return sessionError("scp", waitErr, &stderr)
+			}
+		}
+		return err
+	}
+
+	if err := scpAck(acks); err != nil {
+		return fail(err)
+	}
+	if _, err := fmt.Fprintf(stdin, "C0644 %d %s\n", size, filepath.Base(path)); err != nil {
+		return fail(err)
+	}
+	if err := scpAck(acks); err != nil {
+		return fail(err)
+	}
+
+	src := &io.LimitedReader{R: r, N: size}
+	if err := copyWithProgress(ctx, src, stdin, size, progress, s.limits); err != nil {
+		return fail(err)
+	}
+	if src.N > 0 {
+		return fail(fmt.Errorf("source ended %d bytes short", src.N))
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(err)
+	}
+	if err := scpAck(acks); err != nil {
+		return fail(err)
+	}
+	stdin.Close()
+
+	if err := session.Wait(); err != nil {
+		return sessionError("scp", err, &stderr)
+	}
+	return nil
+}
+
+// scpAck reads the reply to an scp protocol message: a zero byte, or a
+// warning or error code followed by a message line.
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return scpError(msg)
+}
+
+// scpError turns an error line from the remote scp into an error.
+func scpError(msg string) error {
+	return fmt.Errorf("scp: %s", strings.TrimPrefix(strings.TrimSpace(msg), "scp: "))
+}
+
+// Open streams a remote file with "scp -f", returning it with its size.
+func (s *SCPClient) Open(path string) (io.ReadCloser, int64, error) {
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening SSH session: %w: %w", ErrConnectionLost, err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("opening scp input: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("opening scp output: %w", err)
+	}
+	data := bufio.NewReader(stdout)
+
+	if err := session.Start("scp -f " + ShellQuote(path)); err != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("starting scp: %w", err)
+	}
+	fail := func(err error) (io.ReadCloser, int64, error) {
+		stdin.Close()
+		if errors.Is(err, io.EOF) {
+			if waitErr := session.Wait(); waitErr != nil {
+				err = sessionError("scp", waitErr, &stderr)
+			}
+		}
+		session.Close()
+		return nil, 0, fmt.Errorf("opening remote file: %w", err)
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(err)
+	}
+	header, err := data.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if header[0] == 1 || header[0] == 2 {
+		return fail(scpError(header[1:]))
+	}
+	// "C<mode> <size> <name>"
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return fail(fmt.Errorf("scp: unexpected reply %q", strings.TrimSpace(header)))
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fail(fmt.Errorf("scp: bad size in %q", strings.TrimSpace(header)))
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(err)
+	}
+	return &scpReader{r: data, remaining: size, session: session}, size, nil
+}
+
+// scpReader reads one file's data from an "scp -f" session.
+type scpReader struct {
+	r         io.Reader
+	remaining int64
+	session   *ssh.Session
+}
+
+func (r *scpReader) Read(b []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+	n, err := r.r.Read(b)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *scpReader) Close() error {
+	return r.session.Close()
+}
+
+func (s *SCPClient) Pull(ctx context.Context, remotePath, localPath string, progress ProgressFunc) error {
+	remoteFile, size, err := s.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return fmt.Errorf("creating local directory: %w", err)
+	}
+	localFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("creating local file: %w", err)
+	}
+	defer localFile.Close()
+
+	return copyWithProgress(ctx, remoteFile, localFile, size, progress, s.limits)
+}
+
+// CleanupPartials removes partial files and tar staging directories in dir
+// untouched for longer than maxAge, using find on the device.
+func (s *SCPClient) CleanupPartials(dir string, maxAge time.Duration) error {
+	q := ShellQuote(dir)
+	command := fmt.Sprintf(`[ -d %s ] || exit 0; find %s -maxdepth 1 \( -type f -name '.*%s' -o -type d -name '%s*' \) -mmin +%d -exec rm -rf {} \;`,
+		q, q, partialSuffix, tarStagingPrefix, int(maxAge.Minutes()))
+	if _, err := s.output(command); err != nil {
+		return fmt.Errorf("removing partial files in %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Checksum hashes a remote file; see Transport.
+func (s *SCPClient) Checksum(path, algo string, readBack bool) (Checksum, error) {
+	return s.checksum(path, algo, readBack, s.Open)
+}
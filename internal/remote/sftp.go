@@ -1,34 +1,101 @@
 package remote
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+
+	"romrepo/internal/config"
 )
 
 type SFTPClient struct {
+	shell
 	client *sftp.Client
+	window int64 // bytes a push may have in flight
+
+	gid      int    // ID of the configured group, once looked up
+	gidGroup string // group gid was looked up for
 }
 
 type FileInfo struct {
-	Name string
-	Size int64
-	IsDir bool
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time // zero when the transport cannot tell
 }
 
 type ProgressFunc func(transferred, total int64)
 
-func NewSFTPClient(sshConn *ssh.Client) (*SFTPClient, error) {
-	client, err := sftp.NewClient(sshConn)
+// prefixChunk is how much of a partial file verifyPrefix compares at a
+// time.
+const prefixChunk = 1 << 20
+
+// partialSuffix marks the hidden file a push writes to before renaming it
+// into place.
+const partialSuffix = ".romrepo-part"
+
+// PartialMaxAge is how long an untouched partial file is kept for resuming
+// before CleanupPartials treats it as orphaned.
+const PartialMaxAge = 24 * time.Hour
+
+// PartialPath returns the hidden partial file used while pushing remotePath.
+func PartialPath(remotePath string) string {
+	return filepath.Join(filepath.Dir(remotePath), "."+filepath.Base(remotePath)+partialSuffix)
+}
+
+// IsPartial reports whether name is a partial file left by a push.
+func IsPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, partialSuffix)
+}
+
+// ErrPrefixMismatch is returned by Resume when the data already on the
+// device does not match the start of the local file.
+var ErrPrefixMismatch = errors.New("remote data does not match local file")
+
+// IsConnectionLost reports whether err means the connection to the device
+// has dropped, whether SFTP, SCP or FTP, so any further operation on the
+// same client will fail too.
+func IsConnectionLost(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, ErrConnectionLost)
+}
+
+// Defaults for config.SFTPConfig, matching pkg/sftp's own.
+const (
+	defaultSFTPRequests   = 64
+	defaultSFTPPacketSize = 32768
+)
+
+// NewSFTPClient opens an SFTP session whose pushes pipeline up to
+// opts.Requests writes of opts.PacketSize bytes each.
+func NewSFTPClient(sshConn *ssh.Client, opts config.SFTPConfig) (*SFTPClient, error) {
+	requests := cmp.Or(opts.Requests, defaultSFTPRequests)
+	packet := cmp.Or(opts.PacketSize, defaultSFTPPacketSize)
+	client, err := sftp.NewClient(sshConn, sftpOptions(requests, packet)...)
 	if err != nil {
 		return nil, fmt.Errorf("creating SFTP client: %w", err)
 	}
-	return &SFTPClient{client: client}, nil
+	return &SFTPClient{shell: shell{conn: sshConn}, client: client, window: int64(requests) * int64(packet)}, nil
+}
+
+// sftpOptions pipelines writes: up to requests of packet bytes each are in
+// flight per file.
+func sftpOptions(requests, packet int) []sftp.ClientOption {
+	return []sftp.ClientOption{
+		sftp.UseConcurrentWrites(true),
+		sftp.MaxConcurrentRequestsPerFile(requests),
+		sftp.MaxPacketChecked(packet),
+	}
 }
 
 func (s *SFTPClient) Close() error {
@@ -43,13 +110,14 @@ func (s *SFTPClient) ListFiles(dir string) ([]FileInfo, error) {
 
 	var files []FileInfo
 	for _, e := range entries {
-		if e.IsDir() {
+		if e.IsDir() || IsPartial(e.Name()) {
 			continue
 		}
 		files = append(files, FileInfo{
-			Name:  e.Name(),
-			Size:  e.Size(),
-			IsDir: false,
+			Name:    e.Name(),
+			Size:    e.Size(),
+			IsDir:   false,
+			ModTime: e.ModTime(),
 		})
 	}
 	return files, nil
@@ -64,9 +132,10 @@ func (s *SFTPClient) ListDir(dir string) ([]FileInfo, error) {
 	var dirs, files []FileInfo
 	for _, e := range entries {
 		info := FileInfo{
-			Name:  e.Name(),
-			Size:  e.Size(),
-			IsDir: e.IsDir(),
+			Name:    e.Name(),
+			Size:    e.Size(),
+			IsDir:   e.IsDir(),
+			ModTime: e.ModTime(),
 		}
 		if e.IsDir() {
 			dirs = append(dirs, info)
@@ -94,7 +163,134 @@ func (s *SFTPClient) FileExists(path string) bool {
 	return err == nil
 }
 
-func (s *SFTPClient) Push(localPath, remotePath string, progress ProgressFunc) error {
+func (s *SFTPClient) Stat(path string) (FileInfo, error) {
+	info, err := s.client.Stat(path)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return FileInfo{Name: info.Name(), Size: info.Size(), IsDir: info.IsDir(), ModTime: info.ModTime()}, nil
+}
+
+func (s *SFTPClient) Remove(path string) error {
+	if err := s.client.Remove(path); err != nil {
+		return fmt.Errorf("removing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *SFTPClient) MkdirAll(dir string) error {
+	if err := s.mkdirAll(dir); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents, giving the directories it
+// creates the configured mode and group.
+func (s *SFTPClient) mkdirAll(dir string) error {
+	if !s.perm.dirs() {
+		return s.client.MkdirAll(dir)
+	}
+	var missing []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := s.client.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := s.client.MkdirAll(dir); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := s.applyPermissions(missing[i], s.perm.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPermissions gives path mode, unless it is zero, and the configured
+// group. SFTP changes owner and group together, so the owner is read back
+// and kept.
+func (s *SFTPClient) applyPermissions(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := s.client.Chmod(path, mode); err != nil {
+			return fmt.Errorf("setting mode on %s: %w", path, err)
+		}
+	}
+	if s.perm.Group == "" {
+		return nil
+	}
+	if s.gidGroup != s.perm.Group {
+		gid, err := lookupGroup(s.perm.Group, func() (io.ReadCloser, error) { return s.client.Open("/etc/group") })
+		if err != nil {
+			return err
+		}
+		s.gid, s.gidGroup = gid, s.perm.Group
+	}
+	info, err := s.client.Stat(path)
+	if err != nil {
+		return fmt.Errorf("setting group on %s: %w", path, err)
+	}
+	st, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return fmt.Errorf("setting group on %s: owner not reported", path)
+	}
+	if int(st.GID) == s.gid {
+		return nil
+	}
+	if err := s.client.Chown(path, int(st.UID), s.gid); err != nil {
+		return fmt.Errorf("setting group on %s: %w", path, err)
+	}
+	return nil
+}
+
+// FixPermissions applies the configured modes and group to dir and
+// everything below it.
+func (s *SFTPClient) FixPermissions(dir string) error {
+	if s.perm.IsZero() {
+		return nil
+	}
+	walker := s.client.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("fixing permissions in %s: %w", dir, err)
+		}
+		mode := s.perm.FileMode
+		switch info := walker.Stat(); {
+		case info.IsDir():
+			mode = s.perm.DirMode
+		case !info.Mode().IsRegular():
+			continue
+		}
+		if err := s.applyPermissions(walker.Path(), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SFTPClient) Chtimes(path string, mtime time.Time) error {
+	if err := s.client.Chtimes(path, mtime, mtime); err != nil {
+		return fmt.Errorf("setting times on %s: %w", path, err)
+	}
+	return nil
+}
+
+// FreeSpace returns the bytes available on the filesystem holding path,
+// using the statvfs@openssh.com extension or df when the server lacks it.
+func (s *SFTPClient) FreeSpace(path string) (uint64, error) {
+	if _, ok := s.client.HasExtension("statvfs@openssh.com"); !ok {
+		return s.freeSpace(path)
+	}
+	st, err := s.client.StatVFS(path)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space on %s: %w", path, err)
+	}
+	return st.Frsize * st.Bavail, nil
+}
+
+func (s *SFTPClient) Push(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
 	localFile, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("opening local file: %w", err)
@@ -105,22 +301,256 @@ func (s *SFTPClient) Push(localPath, remotePath string, progress ProgressFunc) e
 	if err != nil {
 		return fmt.Errorf("stat local file: %w", err)
 	}
-	totalSize := info.Size()
 
+	return s.PushReader(ctx, localFile, info.Size(), remotePath, progress)
+}
+
+// PushReader writes size bytes read from r to remotePath, creating the
+// remote directory if needed. Data is written to a hidden partial file that
+// is flushed, size-checked and renamed into place only once complete, so an
+// interrupted push never leaves a truncated file under the ROM's name.
+// Cancelling ctx stops the push and removes the partial file; other errors
+// leave it in place for Resume.
+func (s *SFTPClient) PushReader(ctx context.Context, r io.Reader, size int64, remotePath string, progress ProgressFunc) error {
 	// Ensure remote directory exists
 	remoteDir := filepath.Dir(remotePath)
-	s.client.MkdirAll(remoteDir)
+	s.mkdirAll(remoteDir)
 
-	remoteFile, err := s.client.Create(remotePath)
+	partPath := PartialPath(remotePath)
+	remoteFile, err := s.client.Create(partPath)
 	if err != nil {
 		return fmt.Errorf("creating remote file: %w", err)
 	}
 	defer remoteFile.Close()
 
-	return copyWithProgress(localFile, remoteFile, totalSize, progress)
+	if err := s.pushWithProgress(ctx, r, remoteFile, size, progress); err != nil {
+		return s.abortPartial(remoteFile, partPath, err)
+	}
+	return s.finishPartial(remoteFile, partPath, remotePath, size)
+}
+
+// finishPartial flushes and closes a completed partial file, checks its size
+// and renames it over remotePath.
+func (s *SFTPClient) finishPartial(f *sftp.File, partPath, remotePath string, size int64) error {
+	if _, ok := s.client.HasExtension("fsync@openssh.com"); ok {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("syncing remote file: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing remote file: %w", err)
+	}
+
+	info, err := s.client.Stat(partPath)
+	if err != nil {
+		return fmt.Errorf("stat remote file: %w", err)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("remote file is %d bytes, expected %d", info.Size(), size)
+	}
+	if err := s.applyPermissions(partPath, s.perm.FileMode); err != nil {
+		return err
+	}
+
+	return s.rename(partPath, remotePath)
+}
+
+// abortPartial handles a failed copy into a partial file. A cancelled push
+// is not going to be resumed, so its partial file is removed.
+func (s *SFTPClient) abortPartial(f *sftp.File, partPath string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		f.Close()
+		s.client.Remove(partPath)
+	}
+	return err
 }
 
-func (s *SFTPClient) Pull(remotePath, localPath string, progress ProgressFunc) error {
+// rename moves oldPath over newPath, atomically when the server supports
+// posix-rename@openssh.com. Plain SFTP rename fails if the target exists,
+// so without the extension the target is removed first.
+func (s *SFTPClient) rename(oldPath, newPath string) error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		if err := s.client.PosixRename(oldPath, newPath); err != nil {
+			return fmt.Errorf("renaming %s: %w", filepath.Base(oldPath), err)
+		}
+		return nil
+	}
+	if err := s.client.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("replacing %s: %w", filepath.Base(newPath), err)
+	}
+	if err := s.client.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("renaming %s: %w", filepath.Base(oldPath), err)
+	}
+	return nil
+}
+
+// CleanupPartials removes partial files in dir left behind by pushes that
+// never completed, along with the staging directories of dropped tar
+// streams. Files written to within maxAge are kept so that running
+// transfers are untouched and recent interruptions can still be resumed.
+func (s *SFTPClient) CleanupPartials(dir string, maxAge time.Duration) error {
+	entries, err := s.client.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("listing %s: %w", dir, err)
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		if e.ModTime().After(cutoff) {
+			continue
+		}
+		if e.IsDir() && strings.HasPrefix(e.Name(), tarStagingPrefix) {
+			if err := s.client.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				return fmt.Errorf("removing %s: %w", e.Name(), err)
+			}
+			continue
+		}
+		if e.IsDir() || !IsPartial(e.Name()) {
+			continue
+		}
+		if err := s.client.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("removing %s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Open streams a remote file, returning it with its size. Reads are
+// pipelined, feeding the returned reader through an in-memory pipe.
+func (s *SFTPClient) Open(path string) (io.ReadCloser, int64, error) {
+	f, err := s.client.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening remote file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat remote file: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := f.WriteTo(pw)
+		f.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, info.Size(), nil
+}
+
+// Checksum hashes a remote file; see Transport.
+func (s *SFTPClient) Checksum(path, algo string, readBack bool) (Checksum, error) {
+	return s.checksum(path, algo, readBack, s.Open)
+}
+
+// Resume continues an interrupted push of localPath, keeping the bytes already
+// in its partial file once all of them have been verified against the local
+// file. When there is no usable partial file, or its data does not match,
+// it falls back to a full Push.
+func (s *SFTPClient) Resume(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	totalSize := info.Size()
+
+	partPath := PartialPath(remotePath)
+	partInfo, err := s.client.Stat(partPath)
+	// Pipelined writes can leave holes in the last window before an
+	// interruption, so that much is sent again.
+	var offset int64
+	if err == nil && partInfo.Size() <= totalSize {
+		offset = max(0, partInfo.Size()-s.window)
+	}
+	if offset == 0 {
+		return s.PushReader(ctx, localFile, totalSize, remotePath, progress)
+	}
+
+	remoteFile, err := s.client.OpenFile(partPath, os.O_RDWR)
+	if err != nil {
+		return fmt.Errorf("opening remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	if err := s.verifyPartial(localFile, remoteFile, partPath, offset); err != nil {
+		if !errors.Is(err, ErrPrefixMismatch) {
+			return err
+		}
+		// The partial file is left from another version of the ROM, and
+		// resuming it would fail the same way every time.
+		remoteFile.Close()
+		s.client.Remove(partPath)
+		if _, err := localFile.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking local file: %w", err)
+		}
+		return s.PushReader(ctx, localFile, totalSize, remotePath, progress)
+	}
+
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking local file: %w", err)
+	}
+	if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking remote file: %w", err)
+	}
+
+	resumed := func(transferred, total int64) {
+		if progress != nil {
+			progress(offset+transferred, totalSize)
+		}
+	}
+	if err := s.pushWithProgress(ctx, localFile, remoteFile, totalSize-offset, resumed); err != nil {
+		return s.abortPartial(remoteFile, partPath, err)
+	}
+	return s.finishPartial(remoteFile, partPath, remotePath, totalSize)
+}
+
+// verifyPartial checks the first n bytes of the partial file at partPath
+// against local. The device hashes them when it can; otherwise, or when
+// the command fails, they are read back and compared in full.
+func (s *SFTPClient) verifyPartial(local *os.File, remote io.ReaderAt, partPath string, n int64) error {
+	sum, err := s.prefixChecksum(partPath, n)
+	if err != nil {
+		return verifyPrefix(local, remote, n)
+	}
+	want, err := hashReader(io.NewSectionReader(local, 0, n), sum.Algo)
+	if err != nil {
+		return fmt.Errorf("hashing local file: %w", err)
+	}
+	if want != sum.Sum {
+		return fmt.Errorf("verifying %d bytes with %s: %w", n, sum.Method, ErrPrefixMismatch)
+	}
+	return nil
+}
+
+// verifyPrefix compares the first n bytes of local and remote in full.
+func verifyPrefix(local, remote io.ReaderAt, n int64) error {
+	want := make([]byte, prefixChunk)
+	got := make([]byte, prefixChunk)
+	for off := int64(0); off < n; off += prefixChunk {
+		size := min(n-off, prefixChunk)
+		nl, err := local.ReadAt(want[:size], off)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading local file: %w", err)
+		}
+		nr, err := remote.ReadAt(got[:size], off)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading remote file: %w", err)
+		}
+		if nl != nr || !bytes.Equal(want[:nl], got[:nr]) {
+			return fmt.Errorf("verifying %d bytes at offset %d: %w", size, off, ErrPrefixMismatch)
+		}
+	}
+	return nil
+}
+
+func (s *SFTPClient) Pull(ctx context.Context, remotePath, localPath string, progress ProgressFunc) error {
 	remoteFile, err := s.client.Open(remotePath)
 	if err != nil {
 		return fmt.Errorf("opening remote file: %w", err)
@@ -145,16 +575,79 @@ func (s *SFTPClient) Pull(remotePath, localPath string, progress ProgressFunc) e
 	}
 	defer localFile.Close()
 
-	return copyWithProgress(remoteFile, localFile, totalSize, progress)
+	return copyWithProgress(ctx, remoteFile, localFile, totalSize, progress, s.limits)
+}
+
+// pushWithProgress writes total bytes from src to dst with many writes in
+// flight at once. Progress counts data as it enters the pipeline, so it runs
+// up to one window ahead of what the device has acknowledged.
+func (s *SFTPClient) pushWithProgress(ctx context.Context, src io.Reader, dst *sftp.File, total int64, progress ProgressFunc) error {
+	if progress != nil {
+		progress(0, total)
+	}
+	pr := &progressReader{ctx: ctx, r: src, total: total, progress: progress, limits: s.limits}
+	if _, err := dst.ReadFrom(pr); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// progressReader reports, throttles and cancels a pipelined push as data is
+// drawn from its source.
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	read     int64
+	total    int64
+	progress ProgressFunc
+	limits   []*RateLimiter
 }
 
-func copyWithProgress(src io.Reader, dst io.Writer, total int64, progress ProgressFunc) error {
+// Size lets sftp.File.ReadFrom size its pipeline to the transfer.
+func (p *progressReader) Size() int64 {
+	return p.total - p.read
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	if n > 0 {
+		for _, l := range p.limits {
+			if lErr := l.Wait(p.ctx, n); lErr != nil {
+				return 0, lErr
+			}
+		}
+		p.read += int64(n)
+		if p.progress != nil {
+			p.progress(p.read, p.total)
+		}
+	}
+	return n, err
+}
+
+func copyWithProgress(ctx context.Context, src io.Reader, dst io.Writer, total int64, progress ProgressFunc, limits []*RateLimiter) error {
 	buf := make([]byte, 32*1024)
 	var transferred int64
+	if progress != nil {
+		progress(0, total)
+	}
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		n, err := src.Read(buf)
 		if n > 0 {
+			for _, l := range limits {
+				if lErr := l.Wait(ctx, n); lErr != nil {
+					return lErr
+				}
+			}
 			written, wErr := dst.Write(buf[:n])
 			if wErr != nil {
 				return fmt.Errorf("write error: %w", wErr)
@@ -0,0 +1,137 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// benchLatency is the one-way delay of the benchmark link, a round trip of
+// 5ms as on a busy Wi-Fi network.
+const benchLatency = 2500 * time.Microsecond
+
+// BenchmarkSFTPPush pushes a ROM to an in-process SFTP server over a link
+// with latency, comparing how many writes are kept in flight. One request
+// is what the client did before writes were pipelined; 64 is the default.
+func BenchmarkSFTPPush(b *testing.B) {
+	for _, requests := range []int{1, 8, 32, defaultSFTPRequests, 128} {
+		b.Run(fmt.Sprintf("requests=%d", requests), func(b *testing.B) {
+			benchmarkPush(b, requests, defaultSFTPPacketSize)
+		})
+	}
+}
+
+// BenchmarkSFTPPacketSize compares write sizes at the default request count.
+func BenchmarkSFTPPacketSize(b *testing.B) {
+	for _, packet := range []int{8192, 16384, defaultSFTPPacketSize} {
+		b.Run(fmt.Sprintf("packet=%d", packet), func(b *testing.B) {
+			benchmarkPush(b, defaultSFTPRequests, packet)
+		})
+	}
+}
+
+func benchmarkPush(b *testing.B, requests, packet int) {
+	client := newPipeSFTPClient(b, requests, packet)
+	data := bytes.Repeat([]byte("romrepo!"), 8<<20/8)
+	dst := filepath.Join(b.TempDir(), "game.bin")
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for range b.N {
+		if err := client.PushReader(context.Background(), bytes.NewReader(data), int64(len(data)), dst, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newPipeSFTPClient connects an SFTPClient to an in-process server through
+// a pair of delayed pipes.
+func newPipeSFTPClient(tb testing.TB, requests, packet int) *SFTPClient {
+	toServer := newDelayedPipe(benchLatency)
+	toClient := newDelayedPipe(benchLatency)
+
+	server, err := sftp.NewServer(pipeConn{toServer, toClient})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(toClient, toServer, sftpOptions(requests, packet)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		// Closing the server's end first lets the client's reader finish.
+		server.Close()
+		client.Close()
+	})
+	return &SFTPClient{client: client, window: int64(requests) * int64(packet)}
+}
+
+// pipeConn is the server's end: it reads what the client wrote and writes
+// what the client reads.
+type pipeConn struct {
+	in, out *delayedPipe
+}
+
+func (c pipeConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c pipeConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c pipeConn) Close() error {
+	c.in.Close()
+	return c.out.Close()
+}
+
+// delayedPipe is a one-way pipe that delivers each write after a fixed
+// delay, like a link with latency but no bandwidth limit.
+type delayedPipe struct {
+	delay  time.Duration
+	chunks chan delayedChunk
+	done   chan struct{}
+	once   sync.Once
+	buf    []byte
+}
+
+type delayedChunk struct {
+	data []byte
+	at   time.Time
+}
+
+func newDelayedPipe(delay time.Duration) *delayedPipe {
+	return &delayedPipe{delay: delay, chunks: make(chan delayedChunk, 1<<14), done: make(chan struct{})}
+}
+
+func (p *delayedPipe) Write(b []byte) (int, error) {
+	select {
+	case p.chunks <- delayedChunk{data: bytes.Clone(b), at: time.Now().Add(p.delay)}:
+		return len(b), nil
+	case <-p.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (p *delayedPipe) Read(b []byte) (int, error) {
+	if len(p.buf) == 0 {
+		select {
+		case c := <-p.chunks:
+			time.Sleep(time.Until(c.at))
+			p.buf = c.data
+		case <-p.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *delayedPipe) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
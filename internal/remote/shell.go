@@ -0,0 +1,135 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// shell runs commands on a device over SSH exec sessions. It holds what
+// every SSH transport shares.
+type shell struct {
+	conn   *ssh.Client
+	limits []*RateLimiter
+	perm   Permissions
+}
+
+// SetRateLimits throttles data written by this client's pushes and pulls to
+// the slowest of the given limiters.
+func (s *shell) SetRateLimits(limits ...*RateLimiter) {
+	s.limits = limits
+}
+
+// SetPermissions sets the mode and group given to files and directories
+// this client creates.
+func (s *shell) SetPermissions(p Permissions) {
+	s.perm = p
+}
+
+// permCommand returns a command giving target, already quoted or a glob,
+// mode and the configured group, or "" when there is nothing to set.
+func (s *shell) permCommand(mode os.FileMode, target string) string {
+	var cmds []string
+	if mode != 0 {
+		cmds = append(cmds, fmt.Sprintf("chmod %o %s", mode, target))
+	}
+	if s.perm.Group != "" {
+		cmds = append(cmds, "chgrp "+ShellQuote(s.perm.Group)+" "+target)
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// mkdirCommand returns a command creating dir and any missing parents. With
+// a directory mode or group configured, each directory it creates gets
+// them; existing ones are left as they are.
+func (s *shell) mkdirCommand(dir string) string {
+	if !s.perm.dirs() {
+		return "mkdir -p -- " + ShellQuote(dir)
+	}
+	var dirs []string
+	for d := filepath.Clean(dir); d != "/" && d != "."; d = filepath.Dir(d) {
+		dirs = append([]string{ShellQuote(d)}, dirs...)
+	}
+	return "for d in " + strings.Join(dirs, " ") + `; do [ -d "$d" ] || { mkdir -- "$d" && ` +
+		s.permCommand(s.perm.DirMode, `"$d"`) + "; } || exit 1; done"
+}
+
+// fixPermissions applies the configured modes and group to dir and
+// everything below it.
+func (s *shell) fixPermissions(dir string) error {
+	q := ShellQuote(dir)
+	var cmds []string
+	if s.perm.DirMode != 0 {
+		cmds = append(cmds, fmt.Sprintf("find %s -type d -exec chmod %o {} +", q, s.perm.DirMode))
+	}
+	if s.perm.FileMode != 0 {
+		cmds = append(cmds, fmt.Sprintf("find %s -type f -exec chmod %o {} +", q, s.perm.FileMode))
+	}
+	if s.perm.Group != "" {
+		cmds = append(cmds, fmt.Sprintf(`find %s \( -type d -o -type f \) -exec chgrp %s {} +`, q, ShellQuote(s.perm.Group)))
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if _, err := s.output(strings.Join(cmds, " && ")); err != nil {
+		return fmt.Errorf("fixing permissions in %s: %w", dir, err)
+	}
+	return nil
+}
+
+// run executes a shell command on the device, discarding its output.
+func (s *shell) run(command string) error {
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("opening SSH session: %w: %w", ErrConnectionLost, err)
+	}
+	defer session.Close()
+	return session.Run(command)
+}
+
+// output runs a command on the device and returns what it printed. A
+// missing command is reported as errNoCommand.
+func (s *shell) output(command string) (string, error) {
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("opening SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == 127 {
+			return "", errNoCommand
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// freeSpace asks df for the bytes available on the filesystem holding path.
+func (s *shell) freeSpace(path string) (uint64, error) {
+	out, err := s.output("df -Pk " + ShellQuote(path))
+	if err != nil {
+		return 0, fmt.Errorf("checking free space on %s: %w", path, err)
+	}
+	// The last line is the filesystem; long device names never wrap with -P.
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("checking free space on %s: unexpected df output", path)
+	}
+	kb, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space on %s: %w", path, err)
+	}
+	return kb * 1024, nil
+}
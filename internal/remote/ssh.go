@@ -2,57 +2,242 @@ package remote
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 
 	"romrepo/internal/config"
 )
 
+// dialTimeout bounds connecting to each host and the SSH handshake with
+// it, so a device that is switched off fails quickly rather than after
+// the OS gives up on TCP.
+const dialTimeout = 15 * time.Second
+
 type ConnManager struct {
-	mu    sync.Mutex
-	conns map[string]*ssh.Client
+	mu      sync.Mutex
+	conns   map[string]*ssh.Client
+	dialing map[string]*dialCall   // dials in progress, by client
+	idle    map[string][]Transport // transports ready for reuse, by client
+	noSFTP  map[string]*ssh.Client // connections whose server refused SFTP
+}
+
+// dialCall is a dial that callers of Get for the same client wait on.
+type dialCall struct {
+	done chan struct{}
+	conn *ssh.Client
+	err  error
 }
 
 func NewConnManager() *ConnManager {
 	return &ConnManager{
-		conns: make(map[string]*ssh.Client),
+		conns:   make(map[string]*ssh.Client),
+		dialing: make(map[string]*dialCall),
+		idle:    make(map[string][]Transport),
+		noSFTP:  make(map[string]*ssh.Client),
 	}
 }
 
+// Get returns the client's connection, dialling it if there is none or it
+// has dropped. Dials run without m.mu held, so an unreachable device does
+// not hold up the others; concurrent callers for one client share a dial.
 func (m *ConnManager) Get(client config.Client) (*ssh.Client, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	if conn := m.alive(client.Name); conn != nil {
+		return conn, nil
+	}
 
+	m.mu.Lock()
 	if conn, ok := m.conns[client.Name]; ok {
-		// Test if connection is still alive
-		_, _, err := conn.SendRequest("keepalive@romrepo", true, nil)
-		if err == nil {
-			return conn, nil
+		// Dialled by another caller since the keepalive.
+		m.mu.Unlock()
+		return conn, nil
+	}
+	if call, ok := m.dialing[client.Name]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.conn, call.err
+	}
+	call := &dialCall{done: make(chan struct{})}
+	m.dialing[client.Name] = call
+	m.mu.Unlock()
+
+	call.conn, call.err = dial(client)
+
+	m.mu.Lock()
+	delete(m.dialing, client.Name)
+	if call.err == nil {
+		m.conns[client.Name] = call.conn
+	}
+	m.mu.Unlock()
+	close(call.done)
+	return call.conn, call.err
+}
+
+// alive returns the client's pooled connection if it still answers a
+// keepalive, and drops it if it does not.
+func (m *ConnManager) alive(clientName string) *ssh.Client {
+	m.mu.Lock()
+	conn, ok := m.conns[clientName]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if _, _, err := conn.SendRequest("keepalive@romrepo", true, nil); err == nil {
+		return conn
+	}
+	m.mu.Lock()
+	if m.conns[clientName] == conn {
+		delete(m.conns, clientName)
+	}
+	m.mu.Unlock()
+	conn.Close()
+	return nil
+}
+
+// Transport returns a transport on the client's connection, reusing an idle
+// one when there is one. Any number of SFTP sessions can be open over the
+// one connection at once, so transfers to a device run in parallel without
+// redialling. Devices whose server refuses SFTP get an SCPClient, local
+// clients a LocalClient and FTP clients an FTPClient with its own control
+// connection. Each transport applies the client's configured permissions.
+// Hand each transport back with Release.
+func (m *ConnManager) Transport(client config.Client, opts config.SFTPConfig) (Transport, error) {
+	t, err := m.transport(client, opts)
+	if err != nil {
+		return nil, err
+	}
+	t.SetPermissions(PermissionsOf(client))
+	return t, nil
+}
+
+func (m *ConnManager) transport(client config.Client, opts config.SFTPConfig) (Transport, error) {
+	if client.IsLocal() {
+		return NewLocalClient(), nil
+	}
+	if client.IsFTP() {
+		return m.ftpTransport(client)
+	}
+	conn, err := m.Get(client)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	idle := m.idle[client.Name]
+	for len(idle) > 0 {
+		t := idle[len(idle)-1]
+		idle = idle[:len(idle)-1]
+		if connOf(t) == conn {
+			m.idle[client.Name] = idle
+			m.mu.Unlock()
+			return t, nil
 		}
-		conn.Close()
-		delete(m.conns, client.Name)
+		t.Close()
 	}
+	delete(m.idle, client.Name)
+	scpOnly := m.noSFTP[client.Name] == conn
+	m.mu.Unlock()
 
-	conn, err := dial(client)
+	if scpOnly {
+		return NewSCPClient(conn), nil
+	}
+	t, err := NewTransport(conn, opts)
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := t.(*SCPClient); ok {
+		m.mu.Lock()
+		m.noSFTP[client.Name] = conn
+		m.mu.Unlock()
+	}
+	return t, nil
+}
 
-	m.conns[client.Name] = conn
-	return conn, nil
+// ftpTransport reuses an idle FTP connection that still answers, or dials
+// a new one.
+func (m *ConnManager) ftpTransport(client config.Client) (Transport, error) {
+	for {
+		m.mu.Lock()
+		idle := m.idle[client.Name]
+		if len(idle) == 0 {
+			m.mu.Unlock()
+			break
+		}
+		t := idle[len(idle)-1]
+		m.idle[client.Name] = idle[:len(idle)-1]
+		m.mu.Unlock()
+
+		if f, ok := t.(*FTPClient); ok && f.alive() {
+			return f, nil
+		}
+		t.Close()
+	}
+	return DialFTP(client)
+}
+
+// Release returns a transport from Transport for reuse. Transports on a
+// connection that has since been replaced are closed.
+func (m *ConnManager) Release(clientName string, t Transport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := t.(*FTPClient); ok {
+		t.SetRateLimits()
+		m.idle[clientName] = append(m.idle[clientName], t)
+		return
+	}
+	conn := connOf(t)
+	if conn == nil || m.conns[clientName] != conn {
+		t.Close()
+		return
+	}
+	t.SetRateLimits()
+	m.idle[clientName] = append(m.idle[clientName], t)
+}
+
+// NewTransport opens an SFTP session on conn, falling back to SCP when the
+// server has no SFTP subsystem.
+func NewTransport(conn *ssh.Client, opts config.SFTPConfig) (Transport, error) {
+	s, err := NewSFTPClient(conn, opts)
+	if err == nil {
+		return s, nil
+	}
+	// Servers without sftp-server refuse the subsystem, or accept it and
+	// close the channel straight away.
+	if strings.Contains(err.Error(), "subsystem request failed") || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return NewSCPClient(conn), nil
+	}
+	return nil, err
+}
+
+// closeIdle closes the idle transports for a client. m.mu must be held.
+func (m *ConnManager) closeIdle(clientName string) {
+	for _, t := range m.idle[clientName] {
+		t.Close()
+	}
+	delete(m.idle, clientName)
 }
 
 func (m *ConnManager) CloseAll() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	for name := range m.idle {
+		m.closeIdle(name)
+	}
 	for name, conn := range m.conns {
 		conn.Close()
 		delete(m.conns, name)
@@ -63,38 +248,84 @@ func (m *ConnManager) Close(clientName string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.closeIdle(clientName)
 	if conn, ok := m.conns[clientName]; ok {
 		conn.Close()
 		delete(m.conns, clientName)
 	}
 }
 
+// errNoPassword marks a password method skipped because no password has
+// been given yet.
+var errNoPassword = errors.New("password: not entered")
+
+// ErrPasswordNeeded is matched by a PasswordNeededError.
+var ErrPasswordNeeded = errors.New("password needed")
+
+// PasswordNeededError is returned when dialling a client whose auth methods
+// end with a password fails without one: its other methods did not get in,
+// so asking for the password may.
+type PasswordNeededError struct {
+	Client string
+	Err    error
+}
+
+func (e *PasswordNeededError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrPasswordNeeded, e.Err)
+}
+
+func (e *PasswordNeededError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PasswordNeededError) Is(target error) bool {
+	return target == ErrPasswordNeeded
+}
+
+// dial connects to a client, first resolving its Host through
+// ~/.ssh/config and then hopping through any jump hosts.
 func dial(client config.Client) (*ssh.Client, error) {
-	var authMethods []ssh.AuthMethod
+	client = client.ResolveSSH()
+	hops, err := jumpHosts(client)
+	if err != nil {
+		return nil, err
+	}
 
-	switch client.Auth.Method {
-	case "key":
-		keyPath := client.Auth.KeyPath
-		if keyPath == "" {
-			home, _ := os.UserHomeDir()
-			keyPath = home + "/.ssh/id_rsa"
-		}
-		key, err := os.ReadFile(keyPath)
+	var via *ssh.Client
+	for _, hop := range hops {
+		conn, err := dialVia(via, hop)
 		if err != nil {
-			return nil, fmt.Errorf("reading SSH key %s: %w", keyPath, err)
-		}
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("parsing SSH key: %w", err)
+			if via != nil {
+				via.Close()
+			}
+			return nil, fmt.Errorf("jump host: %w", err)
 		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+		closeWith(conn, via)
+		via = conn
+	}
 
-	case "password":
-		authMethods = append(authMethods, ssh.Password(client.Auth.Password))
+	conn, err := dialVia(via, client)
+	if err != nil {
+		if via != nil {
+			via.Close()
+		}
+		return nil, err
+	}
+	closeWith(conn, via)
+	return conn, nil
+}
 
-	default:
-		return nil, fmt.Errorf("unknown auth method: %s", client.Auth.Method)
+// dialVia connects to a client directly, or tunnelled through via when it
+// is set.
+func dialVia(via *ssh.Client, client config.Client) (*ssh.Client, error) {
+	authMethods, skipped, closeAgent, err := sshAuth(client)
+	if err != nil {
+		if errors.Is(err, errNoPassword) {
+			return nil, &PasswordNeededError{Client: client.Name, Err: err}
+		}
+		return nil, err
 	}
+	defer closeAgent()
 
 	port := client.Port
 	if port == 0 {
@@ -115,14 +346,353 @@ func dial(client config.Client) (*ssh.Client, error) {
 		HostKeyAlgorithms: hostKeyAlgorithms(addr),
 	}
 
-	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	var netConn net.Conn
+	if via == nil {
+		netConn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	} else {
+		netConn, err = dialThrough(via, addr)
+	}
+	var conn *ssh.Client
+	if err == nil {
+		conn, err = handshake(netConn, addr, sshConfig)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+		authFailed := strings.Contains(err.Error(), "unable to authenticate")
+		if skipped != nil {
+			// A method that could not be set up may be why none worked.
+			err = fmt.Errorf("%w (skipped: %w)", err, skipped)
+		}
+		err = fmt.Errorf("connecting to %s: %w", addr, err)
+		if authFailed && errors.Is(skipped, errNoPassword) {
+			return nil, &PasswordNeededError{Client: client.Name, Err: err}
+		}
+		return nil, err
 	}
 
 	return conn, nil
 }
 
+// dialThrough opens a channel to addr over via. A jump host that does not
+// answer within dialTimeout has via closed under it.
+func dialThrough(via *ssh.Client, addr string) (net.Conn, error) {
+	timer := time.AfterFunc(dialTimeout, func() { via.Close() })
+	netConn, err := via.Dial("tcp", addr)
+	if !timer.Stop() {
+		if err == nil {
+			netConn.Close()
+		}
+		return nil, fmt.Errorf("jump host timed out after %v", dialTimeout)
+	}
+	return netConn, err
+}
+
+// handshake runs the SSH handshake over netConn, closing it if the handshake
+// takes longer than dialTimeout.
+func handshake(netConn net.Conn, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	timer := time.AfterFunc(dialTimeout, func() { netConn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, sshConfig)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("SSH handshake timed out after %v", dialTimeout)
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// closeWith closes the jump host connection once conn, which runs over it,
+// has closed.
+func closeWith(conn, jump *ssh.Client) {
+	if jump == nil {
+		return
+	}
+	go func() {
+		conn.Wait()
+		jump.Close()
+	}()
+}
+
+// jumpHosts returns the hops named by a resolved client's ProxyJump, in
+// order. Each is resolved through ~/.ssh/config in turn and signs in with
+// the client's agent or key methods; passwords are only for the client
+// itself. A hop's user and key default to the client's, and it shares the
+// client's passphrases.
+func jumpHosts(client config.Client) ([]config.Client, error) {
+	if client.ProxyJump == "" || client.ProxyJump == "none" {
+		return nil, nil
+	}
+
+	var methods []string
+	for _, m := range client.Auth.Methods() {
+		if m != "password" {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) == 0 {
+		methods = []string{"agent", "key"}
+	}
+
+	var hops []config.Client
+	for _, spec := range strings.Split(client.ProxyJump, ",") {
+		spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+		if spec == "" {
+			return nil, fmt.Errorf("invalid proxy jump %q", client.ProxyJump)
+		}
+		hop := config.Client{
+			Name: client.Name,
+			Auth: config.AuthConfig{Method: strings.Join(methods, ",")},
+		}
+		if user, host, ok := strings.Cut(spec, "@"); ok {
+			hop.User, spec = user, host
+		}
+		hop.Host = spec
+		if host, port, err := net.SplitHostPort(spec); err == nil {
+			n, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy jump port in %q", spec)
+			}
+			hop.Host, hop.Port = host, n
+		}
+
+		hop = hop.ResolveSSH()
+		if hop.User == "" {
+			hop.User = client.User
+		}
+		if _, err := os.Stat(hop.Auth.KeyPath); err != nil {
+			// Without a key of its own the hop shares the client's.
+			hop.Auth.KeyPath = client.Auth.KeyPath
+		}
+		hop.Auth.Passphrases = client.Auth.Passphrases
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// sshAuth builds the auth methods for a client in the order its config
+// lists them. Agent and key signers share one public key method, as the
+// SSH client offers each method only once. Methods that cannot be set up,
+// such as an agent that is not running, are skipped and returned as
+// skipped, unless nothing is left to try. So is a password listed with
+// other methods but not entered yet. closeAgent must be called once
+// the handshake is over.
+func sshAuth(client config.Client) (methods []ssh.AuthMethod, skipped error, closeAgent func(), err error) {
+	closeAgent = func() {}
+	var signers []func() ([]ssh.Signer, error)
+	var errs []error
+	for _, method := range client.Auth.Methods() {
+		switch method {
+		case "agent":
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				errs = append(errs, fmt.Errorf("agent: SSH_AUTH_SOCK is not set"))
+				continue
+			}
+			agentConn, err := net.Dial("unix", sock)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("agent: %w", err))
+				continue
+			}
+			closeAgent = func() { agentConn.Close() }
+			signers = append(signers, agent.NewClient(agentConn).Signers)
+
+		case "key":
+			path := keyPath(client.Auth)
+			signer, err := loadKey(path, client.Auth.Passphrases[path])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signers = append(signers, func() ([]ssh.Signer, error) { return []ssh.Signer{signer}, nil })
+
+		case "password":
+			if client.Auth.Password == "" && len(client.Auth.Methods()) > 1 {
+				// Left as a fallback, asked for once the rest have failed.
+				errs = append(errs, errNoPassword)
+				continue
+			}
+			methods = append(methods, ssh.Password(client.Auth.Password))
+
+		default:
+			return nil, nil, closeAgent, fmt.Errorf("unknown auth method: %s", method)
+		}
+		if len(signers) == 1 && (method == "agent" || method == "key") {
+			methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				var all []ssh.Signer
+				for _, get := range signers {
+					// An agent that fails mid-handshake leaves the other keys.
+					if s, err := get(); err == nil {
+						all = append(all, s...)
+					}
+				}
+				return all, nil
+			}))
+		}
+	}
+	skipped = errors.Join(errs...)
+	if len(methods) == 0 {
+		closeAgent()
+		if skipped == nil {
+			return nil, nil, closeAgent, fmt.Errorf("no auth method configured")
+		}
+		return nil, nil, closeAgent, skipped
+	}
+	return methods, skipped, closeAgent, nil
+}
+
+// LockedKeys returns the encrypted keys dialling client needs a passphrase
+// for, those of its jump hosts first. Keys with a passphrase in
+// client.Auth.Passphrases are left out, as are keys held by an agent
+// tried alongside them.
+func LockedKeys(client config.Client) []string {
+	if !client.IsSSH() {
+		return nil
+	}
+	client = client.ResolveSSH()
+	hops, _ := jumpHosts(client)
+	var locked []string
+	for _, c := range append(hops, client) {
+		if !c.Auth.Uses("key") {
+			continue
+		}
+		path := keyPath(c.Auth)
+		if _, ok := client.Auth.Passphrases[path]; ok || slices.Contains(locked, path) {
+			continue
+		}
+		if keyNeedsPassphrase(path) && !(c.Auth.Uses("agent") && agentHasKey(path)) {
+			locked = append(locked, path)
+		}
+	}
+	return locked
+}
+
+// keyPath returns the private key an auth config signs with: its own key
+// path, else ~/.ssh/id_rsa.
+func keyPath(auth config.AuthConfig) string {
+	if auth.KeyPath != "" {
+		return auth.KeyPath
+	}
+	home, _ := os.UserHomeDir()
+	return home + "/.ssh/id_rsa"
+}
+
+// keyNeedsPassphrase reports whether the private key at keyPath is
+// encrypted. A key that cannot be read or parsed reports false and fails
+// when dialling instead.
+func keyNeedsPassphrase(keyPath string) bool {
+	return readKeyFile(keyPath).encrypted
+}
+
+// agentTimeout bounds asking the agent which keys it holds.
+const agentTimeout = 2 * time.Second
+
+// agentHasKey reports whether the agent at SSH_AUTH_SOCK holds the key at
+// keyPath, so it can sign without the key's passphrase.
+func agentHasKey(keyPath string) bool {
+	public := readKeyFile(keyPath).public
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if public == nil || sock == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", sock, agentTimeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	// An agent that does not answer is treated as not holding the key.
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), public.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFiles remembers what readKeyFile found in each key file, so a key is
+// only read again once it changes.
+var keyFiles struct {
+	sync.Mutex
+	keys map[string]keyFile
+}
+
+type keyFile struct {
+	modTime   time.Time
+	encrypted bool
+	public    ssh.PublicKey // nil when unknown
+}
+
+// readKeyFile parses the key at keyPath without decrypting it.
+func readKeyFile(keyPath string) keyFile {
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		return keyFile{}
+	}
+	keyFiles.Lock()
+	defer keyFiles.Unlock()
+	if k, ok := keyFiles.keys[keyPath]; ok && k.modTime.Equal(info.ModTime()) {
+		return k
+	}
+
+	k := keyFile{modTime: info.ModTime()}
+	if data, err := os.ReadFile(keyPath); err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case err == nil:
+			k.public = signer.PublicKey()
+		case errors.As(err, &missing):
+			k.encrypted, k.public = true, missing.PublicKey
+		}
+	}
+	if k.encrypted && k.public == nil {
+		// Encrypted PEM keys hide their public half; ssh-keygen leaves it
+		// beside them.
+		if data, err := os.ReadFile(keyPath + ".pub"); err == nil {
+			k.public, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+		}
+	}
+	if keyFiles.keys == nil {
+		keyFiles.keys = make(map[string]keyFile)
+	}
+	keyFiles.keys[keyPath] = k
+	return k
+}
+
+// CheckPassphrase reports whether passphrase decrypts the key at keyPath.
+func CheckPassphrase(keyPath, passphrase string) error {
+	_, err := loadKey(keyPath, passphrase)
+	return err
+}
+
+// loadKey reads a private key, decrypting it with passphrase when it is
+// encrypted.
+func loadKey(keyPath, passphrase string) (ssh.Signer, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key %s: %w", keyPath, err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("decrypting SSH key %s: %w", keyPath, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key: %w", err)
+	}
+	return signer, nil
+}
+
 func defaultHostKeyCallback() (ssh.HostKeyCallback, func(string) []string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,145 @@
+package remote
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoTar is returned by PushTar when the device has no tar command.
+var ErrNoTar = errors.New("tar is not available on the device")
+
+// ErrConnectionLost is returned when an SSH exec session ends without an
+// exit status, which means the connection dropped under it.
+var ErrConnectionLost = errors.New("connection lost")
+
+// tarStagingPrefix names the hidden directory a tar stream is unpacked into
+// before its files are moved into place.
+const tarStagingPrefix = ".romrepo-tar-"
+
+// TarFile is a local file sent by PushTar.
+type TarFile struct {
+	LocalPath string
+	Name      string // file name in the destination directory
+}
+
+// PushTar sends files to dir as a single tar archive unpacked by tar on the
+// device, avoiding a round trip per file. The archive is extracted into a
+// hidden staging directory and its files moved into place only once all of
+// them have arrived, so an interrupted stream leaves no truncated ROMs.
+// progress is called with the index of the file being sent.
+func (s *shell) PushTar(ctx context.Context, dir string, files []TarFile, progress func(i int, transferred, total int64)) error {
+	if err := s.run("command -v tar >/dev/null"); err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return ErrNoTar
+		}
+		return err
+	}
+
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("opening SSH session: %w: %w", ErrConnectionLost, err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("opening tar input: %w", err)
+	}
+
+	staging := filepath.Join(dir, tarStagingPrefix+strconv.FormatInt(time.Now().UnixNano(), 36))
+	// Files are named rather than globbed, which would miss dotfiles.
+	staged := make([]string, len(files))
+	for i, f := range files {
+		staged[i] = ShellQuote(filepath.Join(staging, f.Name))
+	}
+	list := strings.Join(staged, " ")
+	extract := fmt.Sprintf("%s && mkdir -p %s && tar -x -f - -C %[2]s", s.mkdirCommand(dir), ShellQuote(staging))
+	if perm := s.permCommand(s.perm.FileMode, list); perm != "" {
+		extract += " && " + perm
+	}
+	script := fmt.Sprintf("%s && mv -f %s %s/; st=$?; rm -rf %s; exit $st",
+		extract, list, ShellQuote(dir), ShellQuote(staging))
+	if err := session.Start(script); err != nil {
+		return fmt.Errorf("starting tar: %w", err)
+	}
+
+	tw := tar.NewWriter(stdin)
+	for i, f := range files {
+		if err := writeTarFile(ctx, tw, f, s.limits, func(transferred, total int64) {
+			if progress != nil {
+				progress(i, transferred, total)
+			}
+		}); err != nil {
+			// Closing stdin early truncates the archive, so tar fails and
+			// the staging directory is removed.
+			stdin.Close()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if waitErr := session.Wait(); waitErr != nil {
+				return sessionError("tar", waitErr, &stderr)
+			}
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		stdin.Close()
+		return fmt.Errorf("finishing archive: %w", err)
+	}
+	stdin.Close()
+
+	if err := session.Wait(); err != nil {
+		return sessionError("tar", err, &stderr)
+	}
+	return nil
+}
+
+// writeTarFile adds one local file to the archive.
+func writeTarFile(ctx context.Context, tw *tar.Writer, f TarFile, limits []*RateLimiter, progress ProgressFunc) error {
+	src, err := os.Open(f.LocalPath)
+	if err != nil {
+		return fmt.Errorf("opening local file: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Name,
+		Size:     info.Size(),
+		Mode:     0o644,
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing %s: %w", f.Name, err)
+	}
+	return copyWithProgress(ctx, src, tw, info.Size(), progress, limits)
+}
+
+// sessionError describes a failed exec session running command.
+func sessionError(command string, err error, stderr *bytes.Buffer) error {
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) {
+		return fmt.Errorf("%s: %w", command, ErrConnectionLost)
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %w: %s", command, err, msg)
+	}
+	return fmt.Errorf("%s: %w", command, err)
+}
@@ -0,0 +1,81 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Transport moves ROMs to and from a device. SFTPClient is used where the
+// device offers SFTP, SCPClient where it only runs commands, FTPClient for
+// FTP-only devices and LocalClient for cards and drives mounted on this
+// machine.
+type Transport interface {
+	ListFiles(dir string) ([]FileInfo, error)
+	ListDir(dir string) ([]FileInfo, error)
+	HomePath() (string, error)
+	FileExists(path string) bool
+	Stat(path string) (FileInfo, error)
+	Remove(path string) error
+	MkdirAll(dir string) error
+	Chtimes(path string, mtime time.Time) error
+	FreeSpace(path string) (uint64, error)
+
+	// Push writes a local file to remotePath through a hidden partial file
+	// renamed into place once complete. Resume does the same, continuing
+	// an interrupted push where the transport can.
+	Push(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error
+	Resume(ctx context.Context, localPath, remotePath string, progress ProgressFunc) error
+	PushReader(ctx context.Context, r io.Reader, size int64, remotePath string, progress ProgressFunc) error
+	Pull(ctx context.Context, remotePath, localPath string, progress ProgressFunc) error
+	Open(path string) (io.ReadCloser, int64, error)
+
+	CleanupPartials(dir string, maxAge time.Duration) error
+	Checksum(path, algo string, readBack bool) (Checksum, error)
+	SetRateLimits(limits ...*RateLimiter)
+	SetPermissions(p Permissions)
+	FixPermissions(dir string) error
+	Close() error
+}
+
+// Shell is implemented by transports that can run commands on the device,
+// which allows tar streams, delta transfers and hashing many files at once.
+type Shell interface {
+	PushTar(ctx context.Context, dir string, files []TarFile, progress func(i int, transferred, total int64)) error
+	PushDelta(ctx context.Context, localPath, remotePath, helper string, progress ProgressFunc) (int64, error)
+	Checksums(dir string, names []string) (algo, method string, sums map[string]string, err error)
+}
+
+var (
+	_ Shell = (*SFTPClient)(nil)
+	_ Shell = (*SCPClient)(nil)
+
+	_ Transport = (*LocalClient)(nil)
+	_ Transport = (*FTPClient)(nil)
+)
+
+// Copy streams srcPath on src to dstPath on dst without staging the file
+// locally.
+func Copy(ctx context.Context, src, dst Transport, srcPath, dstPath string, progress ProgressFunc) error {
+	r, size, err := src.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("opening source file: %w", err)
+	}
+	defer r.Close()
+	return dst.PushReader(ctx, r, size, dstPath, progress)
+}
+
+// connOf returns the SSH connection a transport runs over, or nil for a
+// local one.
+func connOf(t Transport) *ssh.Client {
+	switch t := t.(type) {
+	case *SFTPClient:
+		return t.conn
+	case *SCPClient:
+		return t.conn
+	}
+	return nil
+}
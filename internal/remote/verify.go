@@ -0,0 +1,216 @@
+package remote
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned by Verify when the device copy differs
+// from the source.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrNoChecksumCommand is returned when a file cannot be hashed on the
+// device and reading it back was not allowed, leaving it unverified.
+var ErrNoChecksumCommand = errors.New("no checksum command")
+
+// VerifyReadBack is the Checksum method used when the file was hashed by
+// reading it back over SFTP rather than by a command on the device.
+const VerifyReadBack = "read-back"
+
+// checksumAlgos lists the digests romrepo can compare, strongest first,
+// with the command that computes each on the device.
+var checksumAlgos = []struct {
+	name    string
+	command string
+	new     func() hash.Hash
+}{
+	{"sha1", "sha1sum", sha1.New},
+	{"md5", "md5sum", md5.New},
+}
+
+// Checksum is a file digest and how it was obtained.
+type Checksum struct {
+	Algo   string // "sha1" or "md5"
+	Sum    string // lowercase hex
+	Method string // device command, or VerifyReadBack
+}
+
+// checksum hashes a remote file. With algo empty the strongest digest the
+// device has a command for is used. When readBack is set and no command is
+// available the file is read back through open instead; otherwise an error
+// wrapping ErrNoChecksumCommand is returned.
+func (s *shell) checksum(path, algo string, readBack bool, open func(string) (io.ReadCloser, int64, error)) (Checksum, error) {
+	for _, a := range checksumAlgos {
+		if algo != "" && a.name != algo {
+			continue
+		}
+		sum, err := s.execChecksum(a.command, path)
+		if err == nil {
+			return Checksum{Algo: a.name, Sum: sum, Method: a.command}, nil
+		}
+		if !errors.Is(err, errNoCommand) {
+			return Checksum{}, err
+		}
+	}
+
+	if !readBack {
+		return Checksum{}, fmt.Errorf("%w on device", ErrNoChecksumCommand)
+	}
+	if algo == "" {
+		algo = checksumAlgos[0].name
+	}
+	f, _, err := open(path)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer f.Close()
+	sum, err := hashReader(f, algo)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("reading back %s: %w", path, err)
+	}
+	return Checksum{Algo: algo, Sum: sum, Method: VerifyReadBack}, nil
+}
+
+// Checksums hashes several files in dir with a single command, returning
+// each digest by name. It fails with an error wrapping ErrNoChecksumCommand
+// when the device has no checksum command.
+func (s *shell) Checksums(dir string, names []string) (algo, method string, sums map[string]string, err error) {
+	args := make([]string, len(names))
+	for i, n := range names {
+		args[i] = ShellQuote(n)
+	}
+	for _, a := range checksumAlgos {
+		out, err := s.output("cd " + ShellQuote(dir) + " && " + a.command + " -- " + strings.Join(args, " "))
+		if errors.Is(err, errNoCommand) {
+			continue
+		}
+		if err != nil {
+			return "", "", nil, fmt.Errorf("%s: %w", a.command, err)
+		}
+		sums := make(map[string]string, len(names))
+		for _, line := range strings.Split(out, "\n") {
+			// Lines are "<digest>  <name>", or "<digest> *<name>" in binary mode.
+			sum, name, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+			sums[name] = strings.ToLower(sum)
+		}
+		return a.name, a.command, sums, nil
+	}
+	return "", "", nil, fmt.Errorf("%w on device", ErrNoChecksumCommand)
+}
+
+// prefixChecksum hashes the first n bytes of a remote file on the device.
+// It fails with an error wrapping ErrNoChecksumCommand when the device has
+// no checksum command.
+func (s *shell) prefixChecksum(path string, n int64) (Checksum, error) {
+	for _, a := range checksumAlgos {
+		out, err := s.output(fmt.Sprintf("head -c %d -- %s | %s", n, ShellQuote(path), a.command))
+		if errors.Is(err, errNoCommand) {
+			continue
+		}
+		if err != nil {
+			return Checksum{}, fmt.Errorf("%s: %w", a.command, err)
+		}
+		fields := strings.Fields(out)
+		if len(fields) == 0 {
+			return Checksum{}, fmt.Errorf("%s: empty output", a.command)
+		}
+		return Checksum{Algo: a.name, Sum: strings.ToLower(fields[0]), Method: a.command}, nil
+	}
+	return Checksum{}, fmt.Errorf("%w on device", ErrNoChecksumCommand)
+}
+
+// errNoCommand reports that a checksum command is not installed on the device.
+var errNoCommand = errors.New("command not found")
+
+// execChecksum runs a *sum command over an SSH exec session and returns the
+// hex digest it prints.
+func (s *shell) execChecksum(command, path string) (string, error) {
+	out, err := s.output(command + " -- " + ShellQuote(path))
+	if errors.Is(err, errNoCommand) {
+		return "", errNoCommand
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s: empty output", command)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// LocalChecksum hashes a local file with the named algorithm.
+func LocalChecksum(path, algo string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening local file: %w", err)
+	}
+	defer f.Close()
+	return hashReader(f, algo)
+}
+
+func hashReader(r io.Reader, algo string) (string, error) {
+	for _, a := range checksumAlgos {
+		if a.name == algo {
+			h := a.new()
+			if _, err := io.Copy(h, r); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+	return "", fmt.Errorf("unknown checksum algorithm %q", algo)
+}
+
+// Verify checks a pushed file against the local original, hashing it on the
+// device when a checksum command is available. When readBack is set devices
+// without one are verified by reading the file back. It returns the method
+// used.
+func Verify(t Transport, localPath, remotePath string, readBack bool) (string, error) {
+	remoteSum, err := t.Checksum(remotePath, "", readBack)
+	if err != nil {
+		return "", err
+	}
+	localSum, err := LocalChecksum(localPath, remoteSum.Algo)
+	if err != nil {
+		return "", err
+	}
+	if localSum != remoteSum.Sum {
+		return remoteSum.Method, fmt.Errorf("%s %s: %w", remoteSum.Algo, remoteSum.Method, ErrChecksumMismatch)
+	}
+	return remoteSum.Method, nil
+}
+
+// VerifyCopy checks a file copied from src to dst against the original on
+// src.
+func VerifyCopy(dst, src Transport, srcPath, dstPath string, readBack bool) (string, error) {
+	dstSum, err := dst.Checksum(dstPath, "", readBack)
+	if err != nil {
+		return "", err
+	}
+	srcSum, err := src.Checksum(srcPath, dstSum.Algo, true)
+	if err != nil {
+		return "", err
+	}
+	if srcSum.Sum != dstSum.Sum {
+		return dstSum.Method, fmt.Errorf("%s %s: %w", dstSum.Algo, dstSum.Method, ErrChecksumMismatch)
+	}
+	return dstSum.Method, nil
+}
+
+// ShellQuote quotes s for use as a single POSIX shell word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
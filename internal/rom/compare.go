@@ -0,0 +1,54 @@
+package rom
+
+import "sort"
+
+// Comparison describes where one file is present across the server library
+// and two client devices. Sizes are -1 where the file is absent.
+type Comparison struct {
+	Name       string
+	ServerSize int64
+	SizeA      int64
+	SizeB      int64
+}
+
+func (c Comparison) OnServer() bool { return c.ServerSize >= 0 }
+func (c Comparison) InA() bool      { return c.SizeA >= 0 }
+func (c Comparison) InB() bool      { return c.SizeB >= 0 }
+
+// Differs reports whether the two devices disagree about the file, either
+// because only one has it or because their copies differ in size.
+func (c Comparison) Differs() bool {
+	return c.InA() != c.InB() || (c.InA() && c.SizeA != c.SizeB)
+}
+
+// Compare lines up the server ROMs with the files on two devices, keyed by
+// file name and sorted alphabetically. Files that only exist on a device are
+// included so that a curated device can be compared against a new one.
+func Compare(serverROMs []ROMFile, a, b map[string]int64) []Comparison {
+	rows := make(map[string]*Comparison)
+	row := func(name string) *Comparison {
+		if r, ok := rows[name]; ok {
+			return r
+		}
+		r := &Comparison{Name: name, ServerSize: -1, SizeA: -1, SizeB: -1}
+		rows[name] = r
+		return r
+	}
+
+	for _, sr := range serverROMs {
+		row(sr.Name).ServerSize = sr.Size
+	}
+	for name, size := range a {
+		row(name).SizeA = size
+	}
+	for name, size := range b {
+		row(name).SizeB = size
+	}
+
+	result := make([]Comparison, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
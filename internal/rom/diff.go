@@ -1,10 +1,13 @@
 package rom
 
+import "time"
+
 type Location int
 
 const (
 	ServerOnly Location = iota
 	OnBoth
+	Stale // on the device, but differing from the server's copy
 )
 
 type ROMStatus struct {
@@ -12,14 +15,46 @@ type ROMStatus struct {
 	Location   Location
 	ServerSize int64
 	ServerPath string
+	SyncedOn   int // number of target devices holding the ROM
+	Targets    int // number of target devices compared; 0 when only one
+}
+
+// ClientFile is what a device listing says about one of its files. ModTime
+// is zero when the device cannot tell.
+type ClientFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// mtimeSlack absorbs FAT's two-second timestamps and devices that can only
+// set whole seconds.
+const mtimeSlack = 2 * time.Second
+
+// IsStale reports whether a device copy differs from the server file in
+// size or, where both times are known, modification time.
+func IsStale(server ROMFile, client ClientFile) bool {
+	if server.Size != client.Size {
+		return true
+	}
+	if server.ModTime.IsZero() || client.ModTime.IsZero() {
+		return false
+	}
+	d := server.ModTime.Sub(client.ModTime)
+	return d > mtimeSlack || d < -mtimeSlack
 }
 
-func Diff(serverROMs []ROMFile, clientFileNames map[string]bool) []ROMStatus {
+// Diff matches server ROMs against a device's files by name. With
+// compareTimes, copies that IsStale are reported as Stale rather than
+// OnBoth, which spots outdated ROMs without hashing anything.
+func Diff(serverROMs []ROMFile, clientFiles map[string]ClientFile, compareTimes bool) []ROMStatus {
 	var result []ROMStatus
 	for _, sr := range serverROMs {
 		loc := ServerOnly
-		if clientFileNames[sr.Name] {
+		if cf, ok := clientFiles[sr.Name]; ok {
 			loc = OnBoth
+			if compareTimes && IsStale(sr, cf) {
+				loc = Stale
+			}
 		}
 		result = append(result, ROMStatus{
 			Name:       sr.Name,
@@ -30,3 +65,17 @@ func Diff(serverROMs []ROMFile, clientFileNames map[string]bool) []ROMStatus {
 	}
 	return result
 }
+
+// CountSynced records on each status how many of the given client file
+// sets contain the ROM, for reporting status across a group of devices.
+func CountSynced(statuses []ROMStatus, clients []map[string]ClientFile) {
+	for i := range statuses {
+		statuses[i].Targets = len(clients)
+		statuses[i].SyncedOn = 0
+		for _, files := range clients {
+			if _, ok := files[statuses[i].Name]; ok {
+				statuses[i].SyncedOn++
+			}
+		}
+	}
+}
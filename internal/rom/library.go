@@ -4,14 +4,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"romrepo/internal/config"
 )
 
 type ROMFile struct {
-	Name string
-	Size int64
-	Path string
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Path    string
 }
 
 // DiscoverConsoles scans the server ROM directory for subdirectories that
@@ -61,38 +63,43 @@ func ListServerROMs(cfg *config.Config, console config.Console) ([]ROMFile, erro
 		return nil, err
 	}
 
-	// If no extensions configured, include all files
-	includeAll := len(console.Extensions) == 0
-
-	extSet := make(map[string]bool, len(console.Extensions))
-	for _, ext := range console.Extensions {
-		extSet[strings.ToLower(ext)] = true
-	}
-
 	var roms []ROMFile
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
-		if !includeAll {
-			ext := strings.ToLower(filepath.Ext(e.Name()))
-			if !extSet[ext] {
-				continue
-			}
+		if !MatchesConsole(console, e.Name()) {
+			continue
 		}
 		info, err := e.Info()
 		if err != nil {
 			continue
 		}
 		roms = append(roms, ROMFile{
-			Name: e.Name(),
-			Size: info.Size(),
-			Path: filepath.Join(dir, e.Name()),
+			Name:    e.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Path:    filepath.Join(dir, e.Name()),
 		})
 	}
 	return roms, nil
 }
 
+// MatchesConsole reports whether a file name has one of the console's ROM
+// extensions. A console with no extensions configured matches every file.
+func MatchesConsole(console config.Console, name string) bool {
+	if len(console.Extensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range console.Extensions {
+		if strings.ToLower(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
 // dirHasFiles returns true if the directory contains at least one non-directory entry.
 func dirHasFiles(path string) bool {
 	entries, err := os.ReadDir(path)
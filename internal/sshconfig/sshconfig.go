@@ -0,0 +1,315 @@
+// Package sshconfig reads the parts of OpenSSH's client config that matter
+// for reaching a device: Host aliases and their HostName, Port, User,
+// IdentityFile and ProxyJump. Other keywords are ignored, and Match blocks
+// never apply.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIncludeDepth stops Include loops.
+const maxIncludeDepth = 8
+
+// Config is a parsed ssh_config file.
+type Config struct {
+	blocks []block
+}
+
+// block is a Host section. Settings before the first Host line form a
+// block matching every host.
+type block struct {
+	patterns []string
+	match    bool // a Match block, which is never applied
+	settings []setting
+}
+
+var everyHost = block{patterns: []string{"*"}}
+
+type setting struct {
+	key   string // lower case
+	value string
+}
+
+// Host is what the config says about one host alias. Empty fields were not
+// set.
+type Host struct {
+	HostName      string
+	Port          int
+	User          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// Path returns the user's config file, ~/.ssh/config.
+func Path() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "config")
+}
+
+var cache struct {
+	sync.Mutex
+	modTime time.Time
+	cfg     *Config
+}
+
+// Default returns the user's config, read again only when the file
+// changes. A missing or unreadable file gives an empty config.
+func Default() *Config {
+	cache.Lock()
+	defer cache.Unlock()
+
+	info, err := os.Stat(Path())
+	if err != nil {
+		return &Config{}
+	}
+	if cache.cfg == nil || !info.ModTime().Equal(cache.modTime) {
+		cfg, err := Load(Path())
+		if err != nil {
+			cfg = &Config{}
+		}
+		cache.cfg, cache.modTime = cfg, info.ModTime()
+	}
+	return cache.cfg
+}
+
+// Load reads a config file and any files it includes.
+func Load(file string) (*Config, error) {
+	cfg := &Config{}
+	if err := cfg.load(file, everyHost, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) load(file string, within block, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.parse(f, file, within, depth)
+}
+
+// Parse reads a config from r. Include lines are resolved against ~/.ssh.
+func Parse(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	if err := cfg.parse(r, Path(), everyHost, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse appends the blocks read from r. Lines before the first Host belong
+// to within: every host at the top level, or the including block.
+func (c *Config) parse(r io.Reader, file string, within block, depth int) error {
+	c.blocks = append(c.blocks, block{patterns: within.patterns, match: within.match})
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		key, args := splitLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, block{patterns: args})
+		case "match":
+			c.blocks = append(c.blocks, block{match: true})
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: includes nested too deeply", file, n)
+			}
+			// Lines after an Include still belong to the block it was in.
+			within := c.blocks[len(c.blocks)-1]
+			for _, pattern := range args {
+				if err := c.include(pattern, within, depth+1); err != nil {
+					return err
+				}
+			}
+			c.blocks = append(c.blocks, block{patterns: within.patterns, match: within.match})
+		default:
+			if len(args) > 0 {
+				b := &c.blocks[len(c.blocks)-1]
+				b.settings = append(b.settings, setting{key: key, value: strings.Join(args, " ")})
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// include reads the files matching pattern, which is relative to ~/.ssh
+// unless absolute.
+func (c *Config) include(pattern string, within block, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(Path()), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("include %s: %w", pattern, err)
+	}
+	for _, f := range files {
+		if err := c.load(f, within, depth); err != nil {
+			return fmt.Errorf("include %s: %w", f, err)
+		}
+	}
+	return nil
+}
+
+// splitLine returns a line's lower-cased keyword and its arguments, which
+// may be quoted. The keyword may be followed by "=" instead of a space.
+func splitLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return key, args
+}
+
+// matches reports whether alias matches the block's patterns: at least one
+// plain pattern and none of the negated ones.
+func (b block) matches(alias string) bool {
+	if b.match {
+		return false
+	}
+	matched := false
+	for _, p := range b.patterns {
+		negated := strings.HasPrefix(p, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(p, "!")), strings.ToLower(alias))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok && !negated
+	}
+	return matched
+}
+
+// Lookup returns the settings for a host alias. As in OpenSSH the first
+// value found for each keyword wins, except IdentityFile, which collects
+// every value.
+func (c *Config) Lookup(alias string) Host {
+	var h Host
+	seen := make(map[string]bool)
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for _, s := range b.settings {
+			if s.key == "identityfile" {
+				h.IdentityFiles = append(h.IdentityFiles, s.value)
+				continue
+			}
+			if seen[s.key] {
+				continue
+			}
+			seen[s.key] = true
+			switch s.key {
+			case "hostname":
+				h.HostName = s.value
+			case "port":
+				h.Port, _ = strconv.Atoi(s.value)
+			case "user":
+				h.User = s.value
+			case "proxyjump":
+				h.ProxyJump = s.value
+			}
+		}
+	}
+
+	h.HostName = expandTokens(h.HostName, alias, "", h.User)
+	hostName := h.HostName
+	if hostName == "" {
+		hostName = alias
+	}
+	for i, f := range h.IdentityFiles {
+		h.IdentityFiles[i] = expandHome(expandTokens(f, hostName, alias, h.User))
+	}
+	return h
+}
+
+// Aliases returns the hosts named outright in Host lines, skipping
+// wildcards and negations, in the order they appear.
+func (c *Config) Aliases() []string {
+	var aliases []string
+	seen := make(map[string]bool)
+	for _, b := range c.blocks {
+		if b.match {
+			continue
+		}
+		for _, p := range b.patterns {
+			if strings.ContainsAny(p, "*?![]") || seen[p] {
+				continue
+			}
+			seen[p] = true
+			aliases = append(aliases, p)
+		}
+	}
+	return aliases
+}
+
+// expandTokens replaces the % tokens OpenSSH allows in HostName and
+// IdentityFile: %h host name, %n alias, %r remote user, %u local user, %d
+// home directory and %%.
+func expandTokens(s, host, alias, remoteUser string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	if alias == "" {
+		alias = host
+	}
+	return strings.NewReplacer("%%", "%", "%h", host, "%n", alias, "%r", remoteUser, "%u", local, "%d", home).Replace(s)
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, p[1:])
+	}
+	return p
+}
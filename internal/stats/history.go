@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSamples is how many recent transfers are kept per device.
+const maxSamples = 20
+
+// Sample is one completed transfer.
+type Sample struct {
+	Bytes   int64         `yaml:"bytes"`
+	Elapsed time.Duration `yaml:"elapsed"`
+	At      time.Time     `yaml:"at"`
+}
+
+// History records recent transfer speeds per device, persisted to a YAML
+// file. It is not safe for concurrent use.
+type History struct {
+	path    string
+	Devices map[string][]Sample `yaml:"devices"`
+}
+
+// Load reads the history at path, returning an empty history if the file
+// does not exist.
+func Load(path string) (*History, error) {
+	h := &History{path: path, Devices: make(map[string][]Sample)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("reading transfer stats: %w", err)
+	}
+	if err := yaml.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("parsing transfer stats: %w", err)
+	}
+	if h.Devices == nil {
+		h.Devices = make(map[string][]Sample)
+	}
+	return h, nil
+}
+
+// Save writes the history to disk, replacing the previous file atomically.
+func (h *History) Save() error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return fmt.Errorf("creating stats directory: %w", err)
+	}
+	data, err := yaml.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("marshaling transfer stats: %w", err)
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing transfer stats: %w", err)
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return fmt.Errorf("writing transfer stats: %w", err)
+	}
+	return nil
+}
+
+// Record adds a completed transfer for device, dropping the oldest sample
+// once maxSamples are kept. Transfers too small or quick to time are ignored.
+func (h *History) Record(device string, bytes int64, elapsed time.Duration) {
+	if bytes <= 0 || elapsed < 100*time.Millisecond {
+		return
+	}
+	samples := append(h.Devices[device], Sample{Bytes: bytes, Elapsed: elapsed, At: time.Now()})
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	h.Devices[device] = samples
+}
+
+// Speed returns the typical transfer speed to device in bytes per second,
+// weighting each recent transfer by its size.
+func (h *History) Speed(device string) (float64, bool) {
+	var bytes int64
+	var elapsed time.Duration
+	for _, s := range h.Devices[device] {
+		bytes += s.Bytes
+		elapsed += s.Elapsed
+	}
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return float64(bytes) / elapsed.Seconds(), true
+}
+
+// Estimate predicts how long pushing bytes to device will take.
+func (h *History) Estimate(device string, bytes int64) (time.Duration, bool) {
+	speed, ok := h.Speed(device)
+	if !ok || speed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(bytes) / speed * float64(time.Second)), true
+}
@@ -1,16 +1,22 @@
 package tui
 
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
 	"romrepo/internal/config"
+	"romrepo/internal/queue"
 	"romrepo/internal/remote"
+	"romrepo/internal/stats"
 )
 
 // PanelID identifies which panel has focus.
@@ -18,6 +24,7 @@ type PanelID int
 
 const (
 	PanelDevices PanelID = iota
+	PanelQueue
 	PanelScan
 	PanelConsoles
 	PanelROMs
@@ -30,15 +37,17 @@ type AppMode int
 const (
 	ModeNormal AppMode = iota
 	ModeEditing
-	ModeTransfer
 	ModeSettings
 	ModePassword
+	ModeCompare
 )
 
 const (
 	pendingNone = iota
 	pendingLoadROMs
 	pendingTransfer
+	pendingCompare
+	pendingQueue // queued jobs waiting for a device's credentials
 )
 
 const banner = "" +
@@ -80,6 +89,7 @@ type App struct {
 	height   int
 
 	devicePanel   DevicePanel
+	queuePanel    QueuePanel
 	scanPanel     ScanPanel
 	consolePanel  ConsolePanel
 	romPanel      ROMPanel
@@ -91,31 +101,50 @@ type App struct {
 	selectedClient  *config.Client
 	selectedConsole *config.Console
 
+	queue  *queue.Queue
+	runner *queueRunner
+	stats  *stats.History
+
 	passwords     map[string]string
+	fallback      map[string]bool     // clients whose methods before "password" have failed
+	passphrases   map[string]string   // by key path, entered this session
+	keyChecks     map[string]keyCheck // by client
+	checkingKeys  map[string]bool     // clients whose keys are being checked
 	pendingAction struct {
 		kind     int
-		romNames []string
+		transfer TransferStartMsg
+		compare  CompareStartMsg
+		device   string // client prompted for by the queue
 	}
 }
 
-func NewApp(cfg *config.Config, connMgr *remote.ConnManager, cfgPath string) *App {
+func NewApp(cfg *config.Config, connMgr *remote.ConnManager, q *queue.Queue, history *stats.History, cfgPath string) *App {
 	h := help.New()
 	h.ShowAll = false
 
 	app := &App{
-		cfg:       cfg,
-		cfgPath:   cfgPath,
-		connMgr:   connMgr,
-		keys:      DefaultKeyMap(),
-		help:      h,
-		width:     80,
-		height:    24,
-		focus:     PanelDevices,
-		mode:      ModeNormal,
-		passwords: make(map[string]string),
+		cfg:          cfg,
+		cfgPath:      cfgPath,
+		connMgr:      connMgr,
+		keys:         DefaultKeyMap(),
+		help:         h,
+		width:        80,
+		height:       24,
+		focus:        PanelDevices,
+		mode:         ModeNormal,
+		passwords:    make(map[string]string),
+		fallback:     make(map[string]bool),
+		passphrases:  make(map[string]string),
+		keyChecks:    make(map[string]keyCheck),
+		checkingKeys: make(map[string]bool),
+		queue:        q,
+		runner:       newQueueRunner(),
+		stats:        history,
 	}
+	app.applyRateLimits()
 
 	app.devicePanel = NewDevicePanel(app)
+	app.queuePanel = NewQueuePanel(app)
 	app.scanPanel = NewScanPanel(app)
 	app.consolePanel = NewConsolePanel(app)
 	app.romPanel = NewROMPanel(app)
@@ -125,7 +154,8 @@ func NewApp(cfg *config.Config, connMgr *remote.ConnManager, cfgPath string) *Ap
 }
 
 func (a *App) Init() tea.Cmd {
-	return nil
+	// Pick up jobs left queued by a previous session.
+	return a.runQueue()
 }
 
 func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -171,6 +201,9 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case msg.String() == "s":
 			a.scanPanel.StartScan()
 			return a, a.scanPanel.Init()
+
+		case key.Matches(msg, a.keys.Compare):
+			return a, a.startCompare()
 		}
 
 		// Route to focused panel
@@ -178,6 +211,8 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch a.focus {
 		case PanelDevices:
 			cmd = a.devicePanel.Update(msg)
+		case PanelQueue:
+			cmd = a.queuePanel.Update(msg)
 		case PanelScan:
 			cmd = a.scanPanel.Update(msg)
 		case PanelConsoles:
@@ -197,10 +232,15 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case SelectConsoleMsg:
 		a.selectedConsole = &msg.Console
 		a.focus = PanelROMs
+		if a.selectedClient != nil {
+			if cmd, ok := a.checkKeys(msg, *a.selectedClient); !ok {
+				return a, cmd
+			}
+		}
 		if a.selectedClient != nil && a.needsPassword(a.selectedClient) {
 			a.pendingAction.kind = pendingLoadROMs
 			a.mode = ModePassword
-			a.overlay = NewPasswordModel(a, a.selectedClient.Name, a.selectedClient.Host, a.selectedClient.User)
+			a.overlay = a.credentialPrompt(a.selectedClient)
 			return a, a.overlay.Init()
 		}
 		return a, a.romPanel.LoadROMs()
@@ -214,51 +254,113 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return a, cmd
 
 	case TransferStartMsg:
-		if a.selectedClient != nil && a.needsPassword(a.selectedClient) {
-			a.pendingAction.kind = pendingTransfer
-			a.pendingAction.romNames = msg.ROMNames
-			a.mode = ModePassword
-			a.overlay = NewPasswordModel(a, a.selectedClient.Name, a.selectedClient.Host, a.selectedClient.User)
-			return a, a.overlay.Init()
+		// Prompt for each target's password in turn; PasswordEnteredMsg
+		// re-sends this message until none are missing.
+		clients := msg.Clients
+		if msg.Source != nil {
+			clients = append([]config.Client{*msg.Source}, clients...)
 		}
-		a.mode = ModeTransfer
-		a.overlay = NewTransferModel(a, msg.ROMNames)
-		return a, a.overlay.Init()
-
-	case TransferCompleteMsg:
-		if a.overlay != nil {
-			cmd := a.overlay.Update(msg)
+		if cmd, ok := a.checkKeys(msg, clients...); !ok {
 			return a, cmd
 		}
-		return a, nil
+		for i := range clients {
+			c := &clients[i]
+			if a.needsPassword(c) {
+				a.pendingAction.kind = pendingTransfer
+				a.pendingAction.transfer = msg
+				a.mode = ModePassword
+				a.overlay = a.credentialPrompt(c)
+				return a, a.overlay.Init()
+			}
+		}
+		a.romPanel.selected = make(map[string]bool)
+		return a, a.enqueue(msg)
 
-	case transferTickMsg:
-		if a.overlay != nil {
-			cmd := a.overlay.Update(msg)
+	case CompareStartMsg:
+		if cmd, ok := a.checkKeys(msg, msg.A, msg.B); !ok {
 			return a, cmd
 		}
-		return a, nil
+		for _, c := range []config.Client{msg.A, msg.B} {
+			if a.needsPassword(&c) {
+				a.pendingAction.kind = pendingCompare
+				a.pendingAction.compare = msg
+				a.mode = ModePassword
+				a.overlay = a.credentialPrompt(&c)
+				return a, a.overlay.Init()
+			}
+		}
+		a.mode = ModeCompare
+		a.overlay = NewCompareModel(a, msg.A, msg.B)
+		return a, a.overlay.Init()
+
+	case QueueJobDoneMsg:
+		job, _ := a.queue.Get(msg.JobID)
+		cmd := a.finishJob(msg)
+		return a, tea.Batch(cmd, a.reloadAfterJob(job))
+
+	case queueTickMsg:
+		a.sampleRate(time.Time(msg))
+		if len(a.runner.runs) == 0 {
+			a.runner.ticking = false
+			return a, nil
+		}
+		return a, queueTick()
+
+	case queueRetryMsg:
+		return a, a.runQueue()
+
+	case keysCheckedMsg:
+		for name, check := range msg.checks {
+			a.keyChecks[name] = check
+			delete(a.checkingKeys, name)
+		}
+		cmd := a.runQueue()
+		if msg.retry != nil {
+			retry := msg.retry
+			cmd = tea.Batch(cmd, func() tea.Msg { return retry })
+		}
+		return a, cmd
 
 	case PasswordEnteredMsg:
-		a.passwords[msg.ClientName] = msg.Password
+		if msg.KeyPath != "" {
+			if err := remote.CheckPassphrase(msg.KeyPath, msg.Password); err != nil {
+				// Ask again rather than cache a passphrase that cannot work.
+				a.overlay = NewPassphraseModel(a, msg.ClientName, msg.KeyPath, "incorrect passphrase")
+				return a, a.overlay.Init()
+			}
+			a.passphrases[msg.KeyPath] = msg.Password
+		} else {
+			a.passwords[msg.ClientName] = msg.Password
+		}
+		delete(a.runner.declined, msg.ClientName)
 		a.overlay = nil
 		pending := a.pendingAction
 		a.pendingAction.kind = pendingNone
-		a.pendingAction.romNames = nil
+		a.pendingAction.transfer = TransferStartMsg{}
+		a.pendingAction.compare = CompareStartMsg{}
+		a.pendingAction.device = ""
 		a.mode = ModeNormal
 		switch pending.kind {
 		case pendingLoadROMs:
-			return a, a.romPanel.LoadROMs()
-		case pendingTransfer:
-			romNames := pending.romNames
-			return a, func() tea.Msg {
-				return TransferStartMsg{ROMNames: romNames}
+			if a.selectedClient != nil && a.needsPassword(a.selectedClient) {
+				// A client can need both a password and a key passphrase.
+				a.pendingAction.kind = pendingLoadROMs
+				a.mode = ModePassword
+				a.overlay = a.credentialPrompt(a.selectedClient)
+				return a, tea.Batch(a.runQueue(), a.overlay.Init())
 			}
+			return a, tea.Batch(a.runQueue(), a.romPanel.LoadROMs())
+		case pendingTransfer:
+			transfer := pending.transfer
+			return a, tea.Batch(a.runQueue(), func() tea.Msg { return transfer })
+		case pendingCompare:
+			compare := pending.compare
+			return a, tea.Batch(a.runQueue(), func() tea.Msg { return compare })
 		}
-		return a, nil
+		// A password may be all a queued job was waiting for.
+		return a, a.runQueue()
 
 	case CancelOverlayMsg:
-		wasTransfer := a.mode == ModeTransfer
 		wasPassword := a.mode == ModePassword
 		if c, ok := a.overlay.(interface{ Close() }); ok {
 			c.Close()
@@ -266,15 +368,16 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.mode = ModeNormal
 		a.overlay = nil
 		if wasPassword {
+			if a.pendingAction.kind == pendingQueue {
+				// Its jobs stay queued without asking again.
+				a.runner.declined[a.pendingAction.device] = true
+			}
 			a.pendingAction.kind = pendingNone
-			a.pendingAction.romNames = nil
+			a.pendingAction.transfer = TransferStartMsg{}
+			a.pendingAction.compare = CompareStartMsg{}
+			a.pendingAction.device = ""
 			return a, nil
 		}
-		// After transfer completes, clear selection and reload ROMs
-		if wasTransfer && a.selectedClient != nil && a.selectedConsole != nil {
-			a.romPanel.selected = make(map[string]bool)
-			return a, a.romPanel.LoadROMs()
-		}
 		return a, nil
 
 	case DirConnectedMsg, DirListedMsg, DirConnectErrorMsg:
@@ -284,6 +387,14 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return a, nil
 
+	case PermissionsFixedMsg:
+		// Reload so the list reflects the device as it now is.
+		if a.selectedClient != nil && a.selectedConsole != nil &&
+			a.selectedClient.Name == msg.Client && a.selectedConsole.Dir == msg.Console {
+			return a, a.romPanel.LoadROMs()
+		}
+		return a, nil
+
 	case ScanResultMsg:
 		cmd := a.scanPanel.HandleScanResult(msg)
 		return a, cmd
@@ -294,11 +405,26 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case ConfigUpdatedMsg:
 		a.cfg = msg.Config
+		// Edited clients may sign with other keys now.
+		clear(a.keyChecks)
+		a.applyRateLimits()
 		a.devicePanel.Rebuild(a.cfg)
 		a.consolePanel.Rebuild(a.cfg)
 		return a, nil
 
 	case ErrorMsg:
+		if name := a.notePasswordNeeded(msg.Err); name != "" && a.mode == ModeNormal && a.overlay == nil {
+			// Ask now rather than leave the error; for the selected device
+			// its ROMs are loaded again once the password is in.
+			if c, ok := a.clientByName(name); ok {
+				if a.selectedClient != nil && a.selectedClient.Name == name {
+					a.pendingAction.kind = pendingLoadROMs
+				}
+				a.mode = ModePassword
+				a.overlay = a.credentialPrompt(&c)
+				return a, a.overlay.Init()
+			}
+		}
 		a.setError(msg.Err.Error())
 		return a, a.clearErrorAfter(5 * time.Second)
 
@@ -357,20 +483,23 @@ func (a *App) View() string {
 		rightW = 20
 	}
 
-	// Left column: two bordered panels stacking to fullH
-	leftInnerTotal := fullH - 4 // 2 borders x 2 panels
-	if leftInnerTotal < 2 {
-		leftInnerTotal = 2
+	// Left column: three bordered panels stacking to fullH
+	leftInnerTotal := fullH - 6 // 2 borders x 3 panels
+	if leftInnerTotal < 3 {
+		leftInnerTotal = 3
 	}
-	deviceInnerH := leftInnerTotal / 2
-	scanInnerH := leftInnerTotal - deviceInnerH
+	deviceInnerH := leftInnerTotal * 40 / 100
+	queueInnerH := leftInnerTotal * 35 / 100
+	scanInnerH := leftInnerTotal - deviceInnerH - queueInnerH
 
 	a.devicePanel.SetSize(leftW, deviceInnerH)
+	a.queuePanel.SetSize(leftW, queueInnerH)
 	a.scanPanel.SetSize(leftW, scanInnerH)
 
 	deviceView := a.devicePanel.View(a.focus == PanelDevices)
+	queueView := a.queuePanel.View(a.focus == PanelQueue)
 	scanView := a.scanPanel.View(a.focus == PanelScan)
-	leftCol := lipgloss.JoinVertical(lipgloss.Left, deviceView, scanView)
+	leftCol := lipgloss.JoinVertical(lipgloss.Left, deviceView, queueView, scanView)
 
 	// ── Combined browser panel: console tabs │ separator │ ROMs ──
 	browserInnerH := fullH - 2 // single border
@@ -453,26 +582,42 @@ func (a *App) buildStatusBar() string {
 		parts = append(parts, styledHint("tab", "panel"))
 		switch a.focus {
 		case PanelDevices:
-			parts = append(parts, styledHint("enter", "select"), styledHint("a", "add"), styledHint("e", "edit"), styledHint("d", "del"), styledHint("c", "settings"))
+			parts = append(parts, styledHint("enter", "select"), styledHint("space", "mark"), styledHint("g", "group"), styledHint("a", "add"), styledHint("e", "edit"), styledHint("d", "del"), styledHint("i", "import"), styledHint("c", "settings"))
+		case PanelQueue:
+			pause := "pause"
+			if a.runner.gate.paused() {
+				pause = "resume"
+			}
+			parts = append(parts, styledHint("K/J", "move"), styledHint("r", "retry"), styledHint("R", "retry failed"), styledHint("esc", "cancel"), styledHint("d", "remove"), styledHint("C", "clear done"), styledHint("p", pause), styledHint("+/-", "device limit"), styledHint("]/[", "global limit"))
 		case PanelScan:
 			parts = append(parts, styledHint("enter", "add device"))
 		case PanelConsoles:
 			parts = append(parts, styledHint("enter", "select"))
 		case PanelROMs:
-			parts = append(parts, styledHint("enter", "select"), styledHint("p", "push"), styledHint("←/→", "filter"))
+			parts = append(parts, styledHint("enter", "select"), styledHint("p", "push"), styledHint("S", "sync"), styledHint("F", "fix perms"), styledHint("r", "refresh"), styledHint("←/→", "filter"))
 		}
 		parts = append(parts, styledHint("s", "scan"), styledHint("?", "help"), styledHint("q", "quit"))
 	case ModeEditing:
 		parts = append(parts, styledHint("enter", "save"), styledHint("esc", "cancel"), styledHint("tab", "field"), styledHint("ctrl+t", "connect"), styledHint("ctrl+b", "browse"))
-	case ModeTransfer:
-		parts = append(parts, StyleHintKey.Render("transferring..."))
 	case ModeSettings:
 		parts = append(parts, styledHint("enter", "save"), styledHint("esc", "cancel"))
 	case ModePassword:
 		parts = append(parts, styledHint("enter", "submit"), styledHint("esc", "cancel"))
+	case ModeCompare:
+		parts = append(parts, styledHint("←/→", "view"), styledHint("c", "copy file"), styledHint("C", "copy all missing"), styledHint("esc", "close"))
 	}
 
 	joined := strings.Join(parts, StyleHintSep.Render(" │ "))
+
+	// Background transfers are summarised at the right-hand end.
+	if q := a.queueStatus(); q != "" {
+		gap := a.width - lipgloss.Width(joined) - lipgloss.Width(q) - 2
+		if gap > 0 {
+			joined += strings.Repeat(" ", gap) + q
+		} else {
+			joined = q + StyleHintSep.Render(" │ ") + joined
+		}
+	}
 	return StyleStatusBar.Width(a.width).Render(joined)
 }
 
@@ -489,19 +634,152 @@ func (a *App) clearErrorAfter(d time.Duration) tea.Cmd {
 	})
 }
 
+// startCompare opens the comparison view for the two marked devices.
+func (a *App) startCompare() tea.Cmd {
+	marked := a.devicePanel.MarkedClients()
+	if len(marked) != 2 || a.selectedConsole == nil {
+		return func() tea.Msg {
+			return ErrorMsg{Err: fmt.Errorf("mark exactly two devices and select a console to compare")}
+		}
+	}
+	return func() tea.Msg { return CompareStartMsg{A: marked[0], B: marked[1]} }
+}
+
+// transferTargets returns the devices a push should go to: every marked
+// device, or the selected device when none are marked.
+func (a *App) transferTargets() []config.Client {
+	if marked := a.devicePanel.MarkedClients(); len(marked) > 0 {
+		return marked
+	}
+	if a.selectedClient != nil {
+		return []config.Client{*a.selectedClient}
+	}
+	return nil
+}
+
+// needsPassword reports whether connecting to c needs a password or key
+// passphrase that has not been entered this session.
 func (a *App) needsPassword(c *config.Client) bool {
-	if c.Auth.Method != "password" {
+	if c.IsLocal() {
+		return false
+	}
+	return a.passwordMissing(c) || a.needsPassphrase(c)
+}
+
+// passwordMissing reports whether c needs a password that has not been
+// entered this session. A password listed after other methods is a
+// fallback, only asked for once a dial has found the others do not work.
+func (a *App) passwordMissing(c *config.Client) bool {
+	if !c.Auth.Uses("password") {
 		return false
 	}
-	_, ok := a.passwords[c.Name]
-	return !ok
+	if _, ok := a.passwords[c.Name]; ok {
+		return false
+	}
+	return len(c.Auth.Methods()) == 1 || a.fallback[c.Name]
+}
+
+// notePasswordNeeded records the client named by a PasswordNeededError in
+// err, so that it is asked for its password from now on, and returns the
+// client's name. Other errors return "".
+func (a *App) notePasswordNeeded(err error) string {
+	var pwErr *remote.PasswordNeededError
+	if !errors.As(err, &pwErr) {
+		return ""
+	}
+	a.fallback[pwErr.Client] = true
+	return pwErr.Client
+}
+
+// needsPassphrase reports whether c or one of its jump hosts signs with
+// an encrypted key whose passphrase has not been entered this session.
+// It goes by the last checkKeys for c.
+func (a *App) needsPassphrase(c *config.Client) bool {
+	return len(a.lockedKeys(c)) > 0
+}
+
+// lockedKeys returns the encrypted keys c still needs passphrases for.
+func (a *App) lockedKeys(c *config.Client) []string {
+	var locked []string
+	for _, k := range a.keyChecks[c.Name].locked {
+		if _, ok := a.passphrases[k]; !ok {
+			locked = append(locked, k)
+		}
+	}
+	return locked
+}
+
+// keyCheckTTL is how long a key check is trusted before it is made again,
+// as keys may since have been changed or added to the agent.
+const keyCheckTTL = time.Minute
+
+// keyCheck is what remote.LockedKeys found for a client, before any
+// passphrases were entered.
+type keyCheck struct {
+	locked []string
+	at     time.Time
+}
+
+// keysCheckedMsg carries the results of checkKeys, and the message to
+// handle again now that they are in.
+type keysCheckedMsg struct {
+	checks map[string]keyCheck
+	retry  tea.Msg
+}
+
+// checkKeys reports whether the keys of clients have been checked recently
+// enough for needsPassphrase to go by. If not, it returns a command that
+// checks them off the UI goroutine, as that reads key files and asks the
+// agent, and then sends retry. Without a retry, clients already being
+// checked are left to that check.
+func (a *App) checkKeys(retry tea.Msg, clients ...config.Client) (tea.Cmd, bool) {
+	var todo []config.Client
+	ready := true
+	for _, c := range clients {
+		check, ok := a.keyChecks[c.Name]
+		if !c.IsSSH() || ok && time.Since(check.at) < keyCheckTTL {
+			continue
+		}
+		ready = false
+		if retry != nil || !a.checkingKeys[c.Name] {
+			todo = append(todo, c)
+			a.checkingKeys[c.Name] = true
+		}
+	}
+	if ready || len(todo) == 0 {
+		return nil, ready
+	}
+	return func() tea.Msg {
+		checks := make(map[string]keyCheck, len(todo))
+		for _, c := range todo {
+			checks[c.Name] = keyCheck{locked: remote.LockedKeys(c), at: time.Now()}
+		}
+		return keysCheckedMsg{checks: checks, retry: retry}
+	}, false
+}
+
+// credentialPrompt returns the dialog asking for whatever c is missing,
+// its password first.
+func (a *App) credentialPrompt(c *config.Client) *PasswordModel {
+	if a.passwordMissing(c) {
+		r := c.ResolveSSH()
+		return NewPasswordModel(a, c.Name, r.Host, r.User)
+	}
+	var keyPath string
+	if locked := a.lockedKeys(c); len(locked) > 0 {
+		keyPath = locked[0]
+	}
+	return NewPassphraseModel(a, c.Name, keyPath, "")
 }
 
 func (a *App) resolvePassword(c config.Client) config.Client {
-	if c.Auth.Method == "password" {
+	if c.Auth.Uses("password") {
 		if pw, ok := a.passwords[c.Name]; ok {
 			c.Auth.Password = pw
 		}
 	}
+	if c.IsSSH() {
+		c.Auth.Passphrases = maps.Clone(a.passphrases)
+	}
 	return c
 }
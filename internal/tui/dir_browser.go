@@ -2,6 +2,7 @@ package tui
 
 import (
 	"fmt"
+	"maps"
 	"path/filepath"
 	"strings"
 
@@ -13,14 +14,14 @@ import (
 )
 
 type DirBrowser struct {
-	app        *App
-	entries    []remote.FileInfo
-	cursor     int
-	path       string
-	loading    bool
-	err        error
-	sftpClient *remote.SFTPClient
-	connected  bool
+	app       *App
+	entries   []remote.FileInfo
+	cursor    int
+	path      string
+	loading   bool
+	err       error
+	transport remote.Transport
+	connected bool
 }
 
 func NewDirBrowser(app *App) *DirBrowser {
@@ -28,9 +29,9 @@ func NewDirBrowser(app *App) *DirBrowser {
 }
 
 func (b *DirBrowser) Close() {
-	if b.sftpClient != nil {
-		b.sftpClient.Close()
-		b.sftpClient = nil
+	if b.transport != nil {
+		b.transport.Close()
+		b.transport = nil
 	}
 }
 
@@ -39,36 +40,36 @@ func (b *DirBrowser) Connect(c config.Client) tea.Cmd {
 	b.err = nil
 	b.connected = false
 	connMgr := b.app.connMgr
+	sftpOpts := b.app.cfg.SFTP
+	if c.IsSSH() {
+		c.Auth.Passphrases = maps.Clone(b.app.passphrases)
+	}
 
 	return func() tea.Msg {
-		sshConn, err := connMgr.Get(c)
-		if err != nil {
-			return DirConnectErrorMsg{Err: err}
-		}
-		sftpClient, err := remote.NewSFTPClient(sshConn)
+		transport, err := connMgr.Transport(c, sftpOpts)
 		if err != nil {
 			return DirConnectErrorMsg{Err: err}
 		}
-		home, err := sftpClient.HomePath()
+		home, err := transport.HomePath()
 		if err != nil {
 			home = "/"
 		}
-		return DirConnectedMsg{SFTPClient: sftpClient, HomePath: home}
+		return DirConnectedMsg{Transport: transport, HomePath: home}
 	}
 }
 
 func (b *DirBrowser) NavigateTo(path string) tea.Cmd {
 	b.loading = true
-	sftpClient := b.sftpClient
+	transport := b.transport
 
 	return func() tea.Msg {
-		entries, err := sftpClient.ListDir(path)
+		entries, err := transport.ListDir(path)
 		return DirListedMsg{Path: path, Entries: entries, Err: err}
 	}
 }
 
 func (b *DirBrowser) HandleConnected(msg DirConnectedMsg) tea.Cmd {
-	b.sftpClient = msg.SFTPClient
+	b.transport = msg.Transport
 	b.connected = true
 	b.loading = false
 	b.err = nil
@@ -78,7 +79,7 @@ func (b *DirBrowser) HandleConnected(msg DirConnectedMsg) tea.Cmd {
 }
 
 func (b *DirBrowser) HandleConnectedWithPath(msg DirConnectedMsg, romDir string) tea.Cmd {
-	b.sftpClient = msg.SFTPClient
+	b.transport = msg.Transport
 	b.connected = true
 	b.loading = false
 	b.err = nil
@@ -4,6 +4,7 @@ import "github.com/charmbracelet/bubbles/key"
 
 type KeyMap struct {
 	Enter     key.Binding
+	Mark      key.Binding
 	Quit      key.Binding
 	Push      key.Binding
 	Help      key.Binding
@@ -11,6 +12,12 @@ type KeyMap struct {
 	Edit      key.Binding
 	Delete    key.Binding
 	Filter    key.Binding
+	Group     key.Binding
+	Import    key.Binding
+	Sync      key.Binding
+	FixPerms  key.Binding
+	Refresh   key.Binding
+	Compare   key.Binding
 	Scan      key.Binding
 	Settings  key.Binding
 	FocusNext key.Binding
@@ -24,6 +31,10 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "select"),
 		),
+		Mark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "mark device"),
+		),
 		Quit: key.NewBinding(
 			key.WithKeys("q", "ctrl+c"),
 			key.WithHelp("q", "quit"),
@@ -52,6 +63,30 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("/"),
 			key.WithHelp("/", "filter"),
 		),
+		Group: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "filter by group"),
+		),
+		Import: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "import ssh config"),
+		),
+		Sync: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "sync missing"),
+		),
+		FixPerms: key.NewBinding(
+			key.WithKeys("F"),
+			key.WithHelp("F", "fix permissions"),
+		),
+		Refresh: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "refresh"),
+		),
+		Compare: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "compare two devices"),
+		),
 		Scan: key.NewBinding(
 			key.WithKeys("s"),
 			key.WithHelp("s", "scan network"),
@@ -82,8 +117,8 @@ func (k KeyMap) ShortHelp() []key.Binding {
 func (k KeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.FocusNext, k.FocusPrev, k.Escape},
-		{k.Enter, k.Push, k.Filter},
-		{k.Add, k.Edit, k.Delete, k.Scan},
+		{k.Enter, k.Mark, k.Push, k.Sync, k.FixPerms, k.Filter},
+		{k.Add, k.Edit, k.Delete, k.Group, k.Import, k.Compare, k.Scan, k.Refresh},
 		{k.Settings, k.Quit, k.Help},
 	}
 }
@@ -1,6 +1,8 @@
 package tui
 
 import (
+	"time"
+
 	"romrepo/internal/config"
 	"romrepo/internal/network"
 	"romrepo/internal/remote"
@@ -19,16 +21,27 @@ type SelectConsoleMsg struct {
 // Data loading messages
 type ROMsLoadedMsg struct {
 	ROMs      []rom.ROMStatus
-	ClientErr error // non-nil if client connection/listing failed
+	ClientErr error  // non-nil if client connection/listing failed
+	Free      uint64 // bytes free in the client's console directory, 0 if unknown
 }
 
 type ROMsLoadErrorMsg struct {
 	Err error
 }
 
+// PermissionsFixedMsg reports that a device's console directory was given
+// its configured permissions.
+type PermissionsFixedMsg struct {
+	Client  string
+	Console string
+}
+
 // Transfer messages
 type TransferStartMsg struct {
 	ROMNames []string
+	Clients  []config.Client // devices to push to, in order
+	Sync     bool            // push only the ROMs each device is missing
+	Source   *config.Client  // copy from this device instead of the server
 }
 
 type TransferProgressMsg struct {
@@ -36,8 +49,30 @@ type TransferProgressMsg struct {
 	Total       int64
 }
 
-type TransferCompleteMsg struct {
-	Err error
+// QueueJobDoneMsg reports the end of a queued job's run.
+type QueueJobDoneMsg struct {
+	JobID       int
+	Device      string
+	Verified    string // how the copy was checked; empty when unverified
+	Skipped     string // why there was nothing to do, if so
+	Err         error
+	Unreachable bool // the device could not be reached; the job stays queued
+	NoTar       bool // the device has no tar; the job is queued again for SFTP
+	NoDelta     bool // the device has no delta helper; the ROM was sent in full
+
+	Bytes   int64         // data sent, excluding any resumed from a partial file
+	Elapsed time.Duration // time spent sending it
+}
+
+// Comparison messages
+type CompareStartMsg struct {
+	A config.Client
+	B config.Client
+}
+
+type CompareLoadedMsg struct {
+	Rows []rom.Comparison
+	Err  error
 }
 
 // Error messages
@@ -71,6 +106,7 @@ type PasswordNeededMsg struct {
 type PasswordEnteredMsg struct {
 	ClientName string
 	Password   string
+	KeyPath    string // set when Password is the passphrase for this key
 }
 
 // Overlay messages
@@ -90,8 +126,8 @@ type DirListedMsg struct {
 }
 
 type DirConnectedMsg struct {
-	SFTPClient *remote.SFTPClient
-	HomePath   string
+	Transport remote.Transport
+	HomePath  string
 }
 
 type DirConnectErrorMsg struct {
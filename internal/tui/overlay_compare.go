@@ -0,0 +1,310 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"romrepo/internal/config"
+	"romrepo/internal/rom"
+)
+
+// Comparison views, cycled with left/right.
+const (
+	compareAll = iota
+	compareOnlyA
+	compareOnlyB
+	compareDiffers
+	compareViewCount
+)
+
+// CompareModel diffs one console's contents on two devices against each
+// other and against the server library.
+type CompareModel struct {
+	app     *App
+	a, b    config.Client
+	console config.Console
+	rows    []rom.Comparison
+	visible []rom.Comparison
+	view    int
+	cursor  int
+	loading bool
+	err     error
+}
+
+func NewCompareModel(app *App, a, b config.Client) *CompareModel {
+	return &CompareModel{
+		app:     app,
+		a:       app.resolvePassword(a),
+		b:       app.resolvePassword(b),
+		console: *app.selectedConsole,
+		loading: true,
+	}
+}
+
+func (m *CompareModel) Init() tea.Cmd {
+	app := m.app
+	a, b := m.a, m.b
+	console := m.console
+
+	return func() tea.Msg {
+		serverROMs, err := rom.ListServerROMs(app.cfg, console)
+		if err != nil {
+			return CompareLoadedMsg{Err: fmt.Errorf("listing server ROMs: %w", err)}
+		}
+		sizesA, err := listClientSizes(app, a, console)
+		if err != nil {
+			return CompareLoadedMsg{Err: fmt.Errorf("%s: %w", a.Name, err)}
+		}
+		sizesB, err := listClientSizes(app, b, console)
+		if err != nil {
+			return CompareLoadedMsg{Err: fmt.Errorf("%s: %w", b.Name, err)}
+		}
+		return CompareLoadedMsg{Rows: rom.Compare(serverROMs, sizesA, sizesB)}
+	}
+}
+
+// listClientSizes returns the size of each ROM file in a client's directory
+// for the console, ignoring files without one of the console's extensions.
+func listClientSizes(app *App, client config.Client, console config.Console) (map[string]int64, error) {
+	files, err := listClientDir(app, client, console.Dir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64, len(files))
+	for _, f := range files {
+		if rom.MatchesConsole(console, f.Name) {
+			sizes[f.Name] = f.Size
+		}
+	}
+	return sizes, nil
+}
+
+func (m *CompareModel) applyView() {
+	m.visible = nil
+	for _, r := range m.rows {
+		var keep bool
+		switch m.view {
+		case compareAll:
+			keep = true
+		case compareOnlyA:
+			keep = r.InA() && !r.InB()
+		case compareOnlyB:
+			keep = r.InB() && !r.InA()
+		case compareDiffers:
+			keep = r.Differs()
+		}
+		if keep {
+			m.visible = append(m.visible, r)
+		}
+	}
+	if m.cursor >= len(m.visible) {
+		m.cursor = max(0, len(m.visible)-1)
+	}
+}
+
+func (m *CompareModel) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case CompareLoadedMsg:
+		m.loading = false
+		m.err = msg.Err
+		m.rows = msg.Rows
+		m.applyView()
+		return nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc", "q":
+			return func() tea.Msg { return CancelOverlayMsg{} }
+
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+
+		case "down", "j":
+			if m.cursor < len(m.visible)-1 {
+				m.cursor++
+			}
+
+		case "left":
+			m.view = (m.view - 1 + compareViewCount) % compareViewCount
+			m.applyView()
+
+		case "right", "tab":
+			m.view = (m.view + 1) % compareViewCount
+			m.applyView()
+
+		case "c":
+			return m.copyCurrent()
+
+		case "C":
+			return m.copyMissing()
+		}
+	}
+	return nil
+}
+
+// copyCurrent copies the file under the cursor to whichever device lacks it.
+func (m *CompareModel) copyCurrent() tea.Cmd {
+	if m.cursor < 0 || m.cursor >= len(m.visible) {
+		return nil
+	}
+	r := m.visible[m.cursor]
+	switch {
+	case r.InA() && !r.InB():
+		return m.copyCmd(m.a, m.b, []string{r.Name})
+	case r.InB() && !r.InA():
+		return m.copyCmd(m.b, m.a, []string{r.Name})
+	}
+	return func() tea.Msg {
+		return ErrorMsg{Err: fmt.Errorf("%s is not on exactly one device", r.Name)}
+	}
+}
+
+// copyMissing copies every file one device has and the other lacks. The
+// "only B" view copies from B to A; every other view copies from A to B.
+func (m *CompareModel) copyMissing() tea.Cmd {
+	fromB := m.view == compareOnlyB
+	src, dst := m.a, m.b
+	if fromB {
+		src, dst = m.b, m.a
+	}
+	var names []string
+	for _, r := range m.rows {
+		if (!fromB && r.InA() && !r.InB()) || (fromB && r.InB() && !r.InA()) {
+			names = append(names, r.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return m.copyCmd(src, dst, names)
+}
+
+func (m *CompareModel) copyCmd(src, dst config.Client, names []string) tea.Cmd {
+	return func() tea.Msg {
+		return TransferStartMsg{
+			ROMNames: names,
+			Clients:  []config.Client{dst},
+			Source:   &src,
+		}
+	}
+}
+
+func (m *CompareModel) viewLabels() []string {
+	return []string{
+		"all",
+		"only " + m.a.Name,
+		"only " + m.b.Name,
+		"differs",
+	}
+}
+
+func (m *CompareModel) View(w, h int) string {
+	var b strings.Builder
+
+	b.WriteString(StylePanelTitleFocused.Render(fmt.Sprintf("Compare %s: %s ⇄ %s", m.console.Dir, m.a.Name, m.b.Name)))
+	b.WriteString("\n")
+
+	if m.loading {
+		b.WriteString(StyleHelp.Render(" Listing both devices..."))
+		return lipgloss.NewStyle().Width(w).Height(h).MaxHeight(h).Render(b.String())
+	}
+	if m.err != nil {
+		b.WriteString(StyleError.Render(fmt.Sprintf("Error: %v", m.err)))
+		return lipgloss.NewStyle().Width(w).Height(h).MaxHeight(h).Render(b.String())
+	}
+
+	var tabs []string
+	for i, label := range m.viewLabels() {
+		if i == m.view {
+			tabs = append(tabs, StyleFilterActive.Render(label))
+		} else {
+			tabs = append(tabs, StyleFilterDim.Render(label))
+		}
+	}
+	b.WriteString(" " + strings.Join(tabs, "  ") + "\n")
+	b.WriteString(StyleInfoDim.Render(" "+m.summary()) + "\n")
+
+	// Column header: S=server, A, B presence markers before the name.
+	b.WriteString(StyleInfoLabel.Render("    S A B  Name") + "\n")
+
+	listH := h - 5
+	if listH < 1 {
+		listH = 1
+	}
+
+	if len(m.visible) == 0 {
+		b.WriteString(StyleHelp.Render(" Nothing to show"))
+		return lipgloss.NewStyle().Width(w).Height(h).MaxHeight(h).Render(b.String())
+	}
+
+	start := 0
+	if m.cursor >= listH {
+		start = m.cursor - listH + 1
+	}
+	end := min(start+listH, len(m.visible))
+
+	for i := start; i < end; i++ {
+		r := m.visible[i]
+		prefix := "  "
+		if i == m.cursor {
+			prefix = StyleCursor.Render("▸") + " "
+		}
+
+		marks := presenceMark(r.OnServer()) + " " + presenceMark(r.InA()) + " " + presenceMark(r.InB())
+
+		name := r.Name
+		switch {
+		case i == m.cursor:
+			name = StyleSelected.Render(name)
+		case r.Differs():
+			name = StyleInfoValue.Render(name)
+		default:
+			name = StyleServerOnly.Render(name)
+		}
+
+		line := prefix + marks + "  " + name
+		if r.InA() && r.InB() && r.SizeA != r.SizeB {
+			line += "  " + StyleFailBadge.Render(fmt.Sprintf("%s ≠ %s", formatSize(r.SizeA), formatSize(r.SizeB)))
+		}
+		b.WriteString(line)
+		if i < end-1 {
+			b.WriteString("\n")
+		}
+	}
+
+	return lipgloss.NewStyle().Width(w).Height(h).MaxHeight(h).Render(b.String())
+}
+
+func presenceMark(present bool) string {
+	if present {
+		return StyleSyncBadge.Render("●")
+	}
+	return StyleUnsyncBadge.Render("·")
+}
+
+// summary counts what each device has that the other lacks, and how far
+// each is from the server library.
+func (m *CompareModel) summary() string {
+	var onlyA, onlyB, missingA, missingB int
+	for _, r := range m.rows {
+		if r.InA() && !r.InB() {
+			onlyA++
+		}
+		if r.InB() && !r.InA() {
+			onlyB++
+		}
+		if r.OnServer() && !r.InA() {
+			missingA++
+		}
+		if r.OnServer() && !r.InB() {
+			missingB++
+		}
+	}
+	return fmt.Sprintf("%s has %d not on %s, %s has %d not on %s · missing from server: %s %d, %s %d",
+		m.a.Name, onlyA, m.b.Name, m.b.Name, onlyB, m.a.Name, m.a.Name, missingA, m.b.Name, missingB)
+}
@@ -2,6 +2,8 @@ package tui
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -14,6 +16,7 @@ import (
 
 const (
 	editInputName = iota
+	editInputTransport
 	editInputHost
 	editInputPort
 	editInputUser
@@ -21,6 +24,9 @@ const (
 	editInputKeyPath
 	editInputPassword
 	editInputROMDir
+	editInputGroups
+	editInputRateLimit
+	editInputConcurrency
 	editInputCount
 )
 
@@ -31,6 +37,7 @@ const (
 
 type EditFormModel struct {
 	app        *App
+	base       config.Client // client being edited; fields without an input are kept
 	inputs     []textinput.Model
 	focusIdx   int
 	editIdx    int // index into cfg.Clients, -1 for new
@@ -45,6 +52,9 @@ func NewEditFormModel(app *App, c *config.Client, editIdx int) *EditFormModel {
 		browser:    NewDirBrowser(app),
 		focusPanel: focusForm,
 	}
+	if c != nil {
+		m.base = *c
+	}
 	m.initInputs(c)
 	return m
 }
@@ -52,8 +62,8 @@ func NewEditFormModel(app *App, c *config.Client, editIdx int) *EditFormModel {
 func (m *EditFormModel) initInputs(c *config.Client) {
 	m.inputs = make([]textinput.Model, editInputCount)
 
-	labels := []string{"Name", "Host", "Port", "User", "Auth Method (key/password)", "Key Path", "Password", "ROM Dir"}
-	placeholders := []string{"my-device", "192.168.1.100", "22", "pi", "key", "~/.ssh/id_rsa", "", "/home/pi/roms"}
+	labels := []string{"Name", "Transport (ssh/ftp/ftps/local)", "Host", "Port", "User", "Auth Method (agent/key/password/anonymous)", "Key Path", "Password", "ROM Dir", "Groups", "Rate Limit", "Parallel Transfers"}
+	placeholders := []string{"my-device", "ssh", "192.168.1.100", "22", "pi", "key", "~/.ssh/id_rsa", "", "/home/pi/roms", "living-room, handhelds", "unlimited, or e.g. 2M", "1"}
 
 	for i := 0; i < editInputCount; i++ {
 		t := textinput.New()
@@ -65,6 +75,8 @@ func (m *EditFormModel) initInputs(c *config.Client) {
 			switch i {
 			case editInputName:
 				t.SetValue(c.Name)
+			case editInputTransport:
+				t.SetValue(c.Transport)
 			case editInputHost:
 				t.SetValue(c.Host)
 			case editInputPort:
@@ -86,6 +98,14 @@ func (m *EditFormModel) initInputs(c *config.Client) {
 				t.EchoMode = textinput.EchoPassword
 			case editInputROMDir:
 				t.SetValue(c.ROMDir)
+			case editInputGroups:
+				t.SetValue(strings.Join(c.Groups, ", "))
+			case editInputRateLimit:
+				t.SetValue(c.RateLimit)
+			case editInputConcurrency:
+				if c.Concurrency > 0 {
+					t.SetValue(strconv.Itoa(c.Concurrency))
+				}
 			}
 		}
 
@@ -106,7 +126,7 @@ func (m *EditFormModel) Init() tea.Cmd {
 	// Auto-connect for existing clients with credentials filled
 	if m.editIdx >= 0 {
 		c := m.buildClientFromForm()
-		if c.Host != "" && c.User != "" && c.Auth.Method != "" {
+		if canConnect(c) {
 			cmds = append(cmds, m.browser.Connect(c))
 		}
 	}
@@ -135,7 +155,7 @@ func (m *EditFormModel) Update(msg tea.Msg) tea.Cmd {
 
 		case "ctrl+t":
 			c := m.buildClientFromForm()
-			if c.Host == "" || c.User == "" || c.Auth.Method == "" {
+			if !canConnect(c) {
 				return func() tea.Msg {
 					return ErrorMsg{Err: fmt.Errorf("host, user, and auth method required to connect")}
 				}
@@ -205,35 +225,89 @@ func (m *EditFormModel) Update(msg tea.Msg) tea.Cmd {
 }
 
 func (m *EditFormModel) buildClientFromForm() config.Client {
+
+	var groups []string
+	for _, g := range strings.Split(m.inputs[editInputGroups].Value(), ",") {
+		if g = strings.TrimSpace(g); g != "" && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+
+	c := m.base
+	c.Name = strings.TrimSpace(m.inputs[editInputName].Value())
+	c.Transport = strings.ToLower(strings.TrimSpace(m.inputs[editInputTransport].Value()))
 	port, _ := strconv.Atoi(m.inputs[editInputPort].Value())
 	if port == 0 {
 		port = 22
+		if c.IsFTP() {
+			port = 21
+		}
 	}
-	return config.Client{
-		Name: strings.TrimSpace(m.inputs[editInputName].Value()),
-		Host: strings.TrimSpace(m.inputs[editInputHost].Value()),
-		Port: port,
-		User: strings.TrimSpace(m.inputs[editInputUser].Value()),
-		Auth: config.AuthConfig{
-			Method:   strings.TrimSpace(m.inputs[editInputAuthMethod].Value()),
-			KeyPath:  strings.TrimSpace(m.inputs[editInputKeyPath].Value()),
-			Password: m.inputs[editInputPassword].Value(),
-		},
-		ROMDir: strings.TrimSpace(m.inputs[editInputROMDir].Value()),
+	c.Host = strings.TrimSpace(m.inputs[editInputHost].Value())
+	c.Port = port
+	c.User = strings.TrimSpace(m.inputs[editInputUser].Value())
+	c.Auth.Method = strings.TrimSpace(m.inputs[editInputAuthMethod].Value())
+	c.Auth.KeyPath = strings.TrimSpace(m.inputs[editInputKeyPath].Value())
+	c.Auth.Password = m.inputs[editInputPassword].Value()
+	c.ROMDir = strings.TrimSpace(m.inputs[editInputROMDir].Value())
+	c.Groups = groups
+	c.RateLimit = strings.TrimSpace(m.inputs[editInputRateLimit].Value())
+	c.Concurrency, _ = strconv.Atoi(strings.TrimSpace(m.inputs[editInputConcurrency].Value()))
+	return c
+}
+
+// canConnect reports whether the form holds enough to browse the client's
+// directories. Local clients need nothing more.
+func canConnect(c config.Client) bool {
+	switch {
+	case c.IsLocal():
+		return true
+	case c.IsFTP() && c.Auth.Method == "anonymous":
+		return c.Host != ""
 	}
+	return c.Host != "" && c.ResolveSSH().User != "" && c.Auth.Method != ""
 }
 
 func (m *EditFormModel) save() tea.Cmd {
 	client := m.buildClientFromForm()
 
-	if client.Name == "" || client.Host == "" || client.User == "" {
+	switch {
+	case !client.IsSSH() && !client.IsFTP() && !client.IsLocal():
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("transport must be ssh, ftp, ftps or local")} }
+	case client.IsFTP() && client.Auth.Method != "password" && client.Auth.Method != "anonymous":
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("FTP auth method must be password or anonymous")} }
+	case client.IsFTP() && (client.Name == "" || client.Host == "" ||
+		client.Auth.Method != "anonymous" && client.User == ""):
+		return func() tea.Msg {
+			return ErrorMsg{Err: fmt.Errorf("name, host, and user (or anonymous auth) are required")}
+		}
+	case client.IsLocal() && (client.Name == "" || !filepath.IsAbs(client.ROMDir)):
+		return func() tea.Msg {
+			return ErrorMsg{Err: fmt.Errorf("name and an absolute ROM dir are required")}
+		}
+	case client.IsSSH() && (client.Name == "" || client.Host == "" || client.ResolveSSH().User == ""):
+		return func() tea.Msg {
+			return ErrorMsg{Err: fmt.Errorf("name, host, and user (or a ~/.ssh/config User) are required")}
+		}
+	}
+	if client.IsSSH() {
+		if err := client.Auth.ValidateSSH(); err != nil {
+			return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("auth method: %w", err)} }
+		}
+	}
+
+	if _, err := config.ParseRate(client.RateLimit); err != nil {
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("rate limit: %w", err)} }
+	}
+	if n := strings.TrimSpace(m.inputs[editInputConcurrency].Value()); n != "" &&
+		(client.Concurrency < 1 || client.Concurrency > config.MaxConcurrency) {
 		return func() tea.Msg {
-			return ErrorMsg{Err: fmt.Errorf("name, host, and user are required")}
+			return ErrorMsg{Err: fmt.Errorf("parallel transfers must be between 1 and %d", config.MaxConcurrency)}
 		}
 	}
 
 	// Cache password in memory but don't persist to config
-	if client.Auth.Method == "password" && client.Auth.Password != "" {
+	if client.Auth.Uses("password") && client.Auth.Password != "" {
 		m.app.passwords[client.Name] = client.Auth.Password
 	}
 	client.Auth.Password = ""
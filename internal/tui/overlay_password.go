@@ -14,6 +14,8 @@ type PasswordModel struct {
 	clientName string
 	host       string
 	user       string
+	keyPath    string // set when asking for a key passphrase
+	errText    string
 	input      textinput.Model
 }
 
@@ -34,6 +36,16 @@ func NewPasswordModel(app *App, clientName, host, user string) *PasswordModel {
 	}
 }
 
+// NewPassphraseModel asks for the passphrase of an encrypted private key in
+// the password dialog. errText explains why it is asked again, if it is.
+func NewPassphraseModel(app *App, clientName, keyPath, errText string) *PasswordModel {
+	m := NewPasswordModel(app, clientName, "", "")
+	m.keyPath = keyPath
+	m.errText = errText
+	m.input.Prompt = "Passphrase: "
+	return m
+}
+
 func (m *PasswordModel) Init() tea.Cmd {
 	return textinput.Blink
 }
@@ -44,9 +56,9 @@ func (m *PasswordModel) Update(msg tea.Msg) tea.Cmd {
 		switch msg.String() {
 		case "enter":
 			pw := m.input.Value()
-			clientName := m.clientName
+			clientName, keyPath := m.clientName, m.keyPath
 			return func() tea.Msg {
-				return PasswordEnteredMsg{ClientName: clientName, Password: pw}
+				return PasswordEnteredMsg{ClientName: clientName, Password: pw, KeyPath: keyPath}
 			}
 		case "esc":
 			return func() tea.Msg { return CancelOverlayMsg{} }
@@ -62,10 +74,20 @@ func (m *PasswordModel) View(w, h int) string {
 	const dialogW = 40
 
 	var b strings.Builder
-	b.WriteString(StylePanelTitleFocused.Render("Password Required"))
-	b.WriteString("\n\n")
-	b.WriteString(fmt.Sprintf("  Host: %s\n", m.host))
-	b.WriteString(fmt.Sprintf("  User: %s\n", m.user))
+	if m.keyPath != "" {
+		b.WriteString(StylePanelTitleFocused.Render("Key Passphrase Required"))
+		b.WriteString("\n\n")
+		b.WriteString(fmt.Sprintf("  Device: %s\n", m.clientName))
+		b.WriteString(fmt.Sprintf("  Key:    %s\n", m.keyPath))
+	} else {
+		b.WriteString(StylePanelTitleFocused.Render("Password Required"))
+		b.WriteString("\n\n")
+		b.WriteString(fmt.Sprintf("  Host: %s\n", m.host))
+		b.WriteString(fmt.Sprintf("  User: %s\n", m.user))
+	}
+	if m.errText != "" {
+		b.WriteString("  " + StyleFailBadge.Render(m.errText) + "\n")
+	}
 	b.WriteString("\n")
 	b.WriteString("  " + m.input.View())
 	b.WriteString("\n\n")
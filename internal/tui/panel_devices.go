@@ -1,6 +1,9 @@
 package tui
 
 import (
+	"fmt"
+	"os/user"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
@@ -8,21 +11,41 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"romrepo/internal/config"
+	"romrepo/internal/sshconfig"
 )
 
+// ungroupedLabel heads the clients that belong to no group.
+const ungroupedLabel = "ungrouped"
+
+// deviceRow is one line of the device list: a group header or a client.
+type deviceRow struct {
+	group string // group the row belongs to ("" for ungrouped)
+	idx   int    // index into items, or -1 for a group header
+}
+
+func (r deviceRow) isHeader() bool { return r.idx < 0 }
+
 type DevicePanel struct {
-	app      *App
-	items    []config.Client
-	cursor   int
-	width    int
-	height   int
+	app       *App
+	items     []config.Client
+	groups    []string
+	rows      []deviceRow
+	cursor    int             // index into rows
+	marked    map[string]bool // client names marked as transfer targets
+	collapsed map[string]bool // group headers folded shut
+	filter    string          // show only this group; "" shows all
+	width     int
+	height    int
 }
 
 func NewDevicePanel(app *App) DevicePanel {
-	return DevicePanel{
-		app:   app,
-		items: app.cfg.Clients,
+	p := DevicePanel{
+		app:       app,
+		marked:    make(map[string]bool),
+		collapsed: make(map[string]bool),
 	}
+	p.Rebuild(app.cfg)
+	return p
 }
 
 func (p *DevicePanel) SetSize(w, h int) {
@@ -32,26 +55,161 @@ func (p *DevicePanel) SetSize(w, h int) {
 
 func (p *DevicePanel) Rebuild(cfg *config.Config) {
 	p.items = cfg.Clients
-	if p.cursor >= len(p.items) {
-		p.cursor = max(0, len(p.items)-1)
+	p.groups = cfg.GroupNames()
+	if p.filter != "" && !slices.Contains(p.groups, p.filter) {
+		p.filter = ""
+	}
+
+	// Drop marks for devices that no longer exist
+	names := make(map[string]bool, len(p.items))
+	for _, c := range p.items {
+		names[c.Name] = true
+	}
+	for name := range p.marked {
+		if !names[name] {
+			delete(p.marked, name)
+		}
+	}
+
+	p.buildRows()
+}
+
+// buildRows lays out the visible rows. Without groups (or with a group
+// filter active) the list is flat; otherwise clients are listed under a
+// header for each group they belong to, with ungrouped clients last.
+func (p *DevicePanel) buildRows() {
+	var rows []deviceRow
+	switch {
+	case p.filter != "":
+		for i, c := range p.items {
+			if c.InGroup(p.filter) {
+				rows = append(rows, deviceRow{group: p.filter, idx: i})
+			}
+		}
+	case len(p.groups) == 0:
+		for i := range p.items {
+			rows = append(rows, deviceRow{idx: i})
+		}
+	default:
+		for _, g := range append(slices.Clone(p.groups), "") {
+			members := p.memberIndexes(g)
+			if len(members) == 0 {
+				continue
+			}
+			rows = append(rows, deviceRow{group: g, idx: -1})
+			if p.collapsed[g] {
+				continue
+			}
+			for _, i := range members {
+				rows = append(rows, deviceRow{group: g, idx: i})
+			}
+		}
 	}
+	p.rows = rows
+	if p.cursor >= len(p.rows) {
+		p.cursor = max(0, len(p.rows)-1)
+	}
+}
+
+// memberIndexes returns the indexes of clients in group, or of clients with
+// no group when group is "".
+func (p *DevicePanel) memberIndexes(group string) []int {
+	var idxs []int
+	for i, c := range p.items {
+		if (group == "" && len(c.Groups) == 0) || (group != "" && c.InGroup(group)) {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// currentRow returns the row under the cursor.
+func (p *DevicePanel) currentRow() (deviceRow, bool) {
+	if p.cursor >= 0 && p.cursor < len(p.rows) {
+		return p.rows[p.cursor], true
+	}
+	return deviceRow{}, false
+}
+
+// toggleGroupMarks marks every client in a group, or unmarks them all if
+// they are already marked.
+func (p *DevicePanel) toggleGroupMarks(group string) {
+	members := p.memberIndexes(group)
+	all := true
+	for _, i := range members {
+		if !p.marked[p.items[i].Name] {
+			all = false
+			break
+		}
+	}
+	for _, i := range members {
+		if all {
+			delete(p.marked, p.items[i].Name)
+		} else {
+			p.marked[p.items[i].Name] = true
+		}
+	}
+}
+
+// cycleFilter steps the group filter through all groups and back to none.
+func (p *DevicePanel) cycleFilter() {
+	if len(p.groups) == 0 {
+		return
+	}
+	i := slices.Index(p.groups, p.filter)
+	switch {
+	case p.filter == "":
+		p.filter = p.groups[0]
+	case i+1 < len(p.groups):
+		p.filter = p.groups[i+1]
+	default:
+		p.filter = ""
+	}
+	p.cursor = 0
+	p.buildRows()
 }
 
 func (p *DevicePanel) Update(msg tea.KeyMsg) tea.Cmd {
+	row, ok := p.currentRow()
+
 	switch {
 	case key.Matches(msg, p.app.keys.Enter):
-		if p.cursor >= 0 && p.cursor < len(p.items) {
-			client := p.items[p.cursor]
-			return func() tea.Msg { return SelectClientMsg{Client: client} }
+		if !ok {
+			return nil
+		}
+		if row.isHeader() {
+			p.collapsed[row.group] = !p.collapsed[row.group]
+			p.buildRows()
+			return nil
+		}
+		client := p.items[row.idx]
+		return func() tea.Msg { return SelectClientMsg{Client: client} }
+
+	case key.Matches(msg, p.app.keys.Mark):
+		if !ok {
+			return nil
+		}
+		if row.isHeader() {
+			p.toggleGroupMarks(row.group)
+			return nil
+		}
+		name := p.items[row.idx].Name
+		if p.marked[name] {
+			delete(p.marked, name)
+		} else {
+			p.marked[name] = true
 		}
 
+	case key.Matches(msg, p.app.keys.Group):
+		p.cycleFilter()
+
 	case msg.String() == "up", msg.String() == "k":
 		if p.cursor > 0 {
 			p.cursor--
 		}
 
 	case msg.String() == "down", msg.String() == "j":
-		if p.cursor < len(p.items)-1 {
+		if p.cursor < len(p.rows)-1 {
 			p.cursor++
 		}
 
@@ -61,15 +219,15 @@ func (p *DevicePanel) Update(msg tea.KeyMsg) tea.Cmd {
 		return p.app.overlay.Init()
 
 	case key.Matches(msg, p.app.keys.Edit):
-		if p.cursor >= 0 && p.cursor < len(p.items) {
+		if ok && !row.isHeader() {
 			p.app.mode = ModeEditing
-			p.app.overlay = NewEditFormModel(p.app, &p.items[p.cursor], p.cursor)
+			p.app.overlay = NewEditFormModel(p.app, &p.items[row.idx], row.idx)
 			return p.app.overlay.Init()
 		}
 
 	case key.Matches(msg, p.app.keys.Delete):
-		if p.cursor >= 0 && p.cursor < len(p.items) {
-			p.app.cfg.Clients = append(p.app.cfg.Clients[:p.cursor], p.app.cfg.Clients[p.cursor+1:]...)
+		if ok && !row.isHeader() {
+			p.app.cfg.Clients = append(p.app.cfg.Clients[:row.idx], p.app.cfg.Clients[row.idx+1:]...)
 			if err := config.Save(p.app.cfg, p.app.cfgPath); err != nil {
 				return func() tea.Msg { return ErrorMsg{Err: err} }
 			}
@@ -77,6 +235,9 @@ func (p *DevicePanel) Update(msg tea.KeyMsg) tea.Cmd {
 			return func() tea.Msg { return ConfigUpdatedMsg{Config: p.app.cfg} }
 		}
 
+	case key.Matches(msg, p.app.keys.Import):
+		return p.importSSHConfig()
+
 	case key.Matches(msg, p.app.keys.Settings):
 		p.app.mode = ModeSettings
 		p.app.overlay = NewSettingsModel(p.app)
@@ -86,6 +247,61 @@ func (p *DevicePanel) Update(msg tea.KeyMsg) tea.Cmd {
 	return nil
 }
 
+// importSSHConfig adds a client for each ~/.ssh/config Host alias that is
+// not already a client's name or host. The alias stays the client's host,
+// so its HostName, Port, User, IdentityFile and ProxyJump keep coming from
+// ~/.ssh/config; only the ROM dir is left to fill in.
+func (p *DevicePanel) importSSHConfig() tea.Cmd {
+	added := 0
+	for _, alias := range sshconfig.Default().Aliases() {
+		c := config.Client{
+			Name: alias,
+			Host: alias,
+			Port: 22,
+			Auth: config.AuthConfig{Method: "agent,key"},
+		}
+		// Skip hosts already configured, by alias or by the address it
+		// stands for.
+		if slices.ContainsFunc(p.app.cfg.Clients, func(e config.Client) bool {
+			return e.Name == alias || e.Host == alias || sameSSHTarget(e, c)
+		}) {
+			continue
+		}
+		if c.ResolveSSH().User == "" {
+			// ssh falls back to the local user name too.
+			if u, err := user.Current(); err == nil {
+				c.User = u.Username
+			}
+		}
+		p.app.cfg.Clients = append(p.app.cfg.Clients, c)
+		added++
+	}
+	if added == 0 {
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("no new hosts in %s", sshconfig.Path())} }
+	}
+	if err := config.Save(p.app.cfg, p.app.cfgPath); err != nil {
+		return func() tea.Msg { return ErrorMsg{Err: err} }
+	}
+	p.Rebuild(p.app.cfg)
+	return func() tea.Msg { return ConfigUpdatedMsg{Config: p.app.cfg} }
+}
+
+// sameSSHTarget reports whether two SSH clients reach the same host and
+// port once ~/.ssh/config is applied.
+func sameSSHTarget(a, b config.Client) bool {
+	if !a.IsSSH() || !b.IsSSH() {
+		return false
+	}
+	ra, rb := a.ResolveSSH(), b.ResolveSSH()
+	port := func(c config.Client) int {
+		if c.Port == 0 {
+			return 22
+		}
+		return c.Port
+	}
+	return strings.EqualFold(ra.Host, rb.Host) && port(ra) == port(rb)
+}
+
 func (p *DevicePanel) View(focused bool) string {
 	// Content area height = panel height minus title line
 	contentH := p.height - 1
@@ -96,6 +312,12 @@ func (p *DevicePanel) View(focused bool) string {
 	var b strings.Builder
 
 	title := "Devices"
+	if p.filter != "" {
+		title += " · " + p.filter
+	}
+	if n := len(p.marked); n > 0 {
+		title += fmt.Sprintf(" (%d marked)", n)
+	}
 	titleStyle := StylePanelTitle
 	if focused {
 		titleStyle = StylePanelTitleFocused
@@ -117,27 +339,45 @@ func (p *DevicePanel) View(focused bool) string {
 			start = p.cursor - visibleLines + 1
 		}
 		end := start + visibleLines
-		if end > len(p.items) {
-			end = len(p.items)
+		if end > len(p.rows) {
+			end = len(p.rows)
 		}
 
+		grouped := p.filter == "" && len(p.groups) > 0
+
 		for i := start; i < end; i++ {
-			c := p.items[i]
+			row := p.rows[i]
 			cursor := "  "
 			if i == p.cursor {
 				cursor = StyleCursor.Render("▸") + " "
 			}
 
-			name := c.Name
-			if p.app.selectedClient != nil && c.Name == p.app.selectedClient.Name {
-				name = StyleSelected.Render(name)
-			} else if i == p.cursor {
-				name = StyleSelected.Render(name)
+			if row.isHeader() {
+				b.WriteString(cursor + p.renderGroupHeader(row.group, i == p.cursor))
 			} else {
-				name = StyleInfoValue.Render(name)
+				c := p.items[row.idx]
+
+				indent := ""
+				if grouped {
+					indent = "  "
+				}
+
+				mark := ""
+				if p.marked[c.Name] {
+					mark = StyleSyncBadge.Render("✓") + " "
+				}
+
+				name := c.Name
+				if p.app.selectedClient != nil && c.Name == p.app.selectedClient.Name {
+					name = StyleSelected.Render(name)
+				} else if i == p.cursor {
+					name = StyleSelected.Render(name)
+				} else {
+					name = StyleInfoValue.Render(name)
+				}
+
+				b.WriteString(cursor + indent + mark + name)
 			}
-
-			b.WriteString(cursor + name)
 			if i < end-1 {
 				b.WriteString("\n")
 			}
@@ -161,9 +401,49 @@ func (p *DevicePanel) View(focused bool) string {
 		Render(content)
 }
 
+func (p *DevicePanel) renderGroupHeader(group string, isCursor bool) string {
+	fold := "▾"
+	if p.collapsed[group] {
+		fold = "▹"
+	}
+	label := group
+	if label == "" {
+		label = ungroupedLabel
+	}
+
+	members := p.memberIndexes(group)
+	marked := 0
+	for _, i := range members {
+		if p.marked[p.items[i].Name] {
+			marked++
+		}
+	}
+
+	style := StyleInfoLabel
+	if isCursor {
+		style = StyleSelected
+	}
+	count := fmt.Sprintf("%d", len(members))
+	if marked > 0 {
+		count = fmt.Sprintf("%d/%d", marked, len(members))
+	}
+	return style.Render(fold+" "+label) + " " + StyleInfoDim.Render(count)
+}
+
+// MarkedClients returns the clients marked as transfer targets, in config order.
+func (p *DevicePanel) MarkedClients() []config.Client {
+	var clients []config.Client
+	for _, c := range p.items {
+		if p.marked[c.Name] {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
 func (p *DevicePanel) SelectedClient() *config.Client {
-	if p.cursor >= 0 && p.cursor < len(p.items) {
-		c := p.items[p.cursor]
+	if row, ok := p.currentRow(); ok && !row.isHeader() {
+		c := p.items[row.idx]
 		return &c
 	}
 	return nil
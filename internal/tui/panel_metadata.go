@@ -3,7 +3,9 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"romrepo/internal/config"
 	"romrepo/internal/rom"
 )
 
@@ -37,16 +39,34 @@ func (p *MetadataPanel) View() string {
 		b.WriteString(StyleInfoValue.Render(p.app.selectedClient.Name))
 		b.WriteString("\n")
 		b.WriteString("            ")
-		b.WriteString(StyleInfoDim.Render(fmt.Sprintf("%s@%s:%d",
-			p.app.selectedClient.User,
-			p.app.selectedClient.Host,
-			p.app.selectedClient.Port)))
+		b.WriteString(StyleInfoDim.Render(clientAddress(p.app.selectedClient)))
+		if speed, ok := p.app.stats.Speed(p.app.selectedClient.Name); ok {
+			b.WriteString(StyleInfoDim.Render("  ~" + formatRate(speed)))
+		}
+		b.WriteString("\n")
+	}
+
+	if marked := p.app.devicePanel.MarkedClients(); len(marked) > 0 {
+		b.WriteString(" " + StyleInfoLabel.Render("Targets") + "   ")
+		b.WriteString(StyleInfoValue.Render(fmt.Sprintf("%d marked device(s)", len(marked))))
 		b.WriteString("\n")
 	}
 
 	if p.app.selectedConsole != nil {
 		b.WriteString(" " + StyleInfoLabel.Render("Console") + "   ")
 		b.WriteString(StyleInfoValue.Render(p.app.selectedConsole.Dir))
+		if free := p.app.romPanel.free; free > 0 {
+			b.WriteString(StyleInfoDim.Render("  " + formatSize(int64(free)) + " free"))
+		}
+		b.WriteString("\n")
+	}
+
+	if n, size := p.app.romPanel.SelectedSize(); n > 0 {
+		b.WriteString(" " + StyleInfoLabel.Render("Selected") + "  ")
+		b.WriteString(StyleInfoValue.Render(fmt.Sprintf("%d ROM(s), %s", n, formatSize(size))))
+		if eta, ok := p.predictPush(size); ok {
+			b.WriteString(StyleInfoDim.Render("  ~" + formatETA(eta)))
+		}
 		b.WriteString("\n")
 	}
 
@@ -56,13 +76,47 @@ func (p *MetadataPanel) View() string {
 		b.WriteString("\n")
 		b.WriteString("            ")
 		b.WriteString(StyleInfoDim.Render(formatSize(r.ServerSize)))
-		if r.Location == rom.OnBoth {
+		switch r.Location {
+		case rom.OnBoth:
 			b.WriteString("  " + StyleSyncBadge.Render("synced"))
-		} else {
+		case rom.Stale:
+			b.WriteString("  " + StyleStaleBadge.Render("stale on device"))
+		default:
 			b.WriteString("  " + StyleUnsyncBadge.Render("not synced"))
 		}
 	}
 
+	if j := p.app.queuePanel.SelectedJob(); j != nil && p.app.focus == PanelQueue {
+		b.WriteString("\n")
+		b.WriteString(" " + StyleInfoLabel.Render("Job") + "       ")
+		b.WriteString(StyleInfoValue.Render(j.ROM))
+		b.WriteString("\n")
+		b.WriteString("            ")
+		route := j.Console + " → " + j.Device
+		if j.Source != "" {
+			route = j.Source + " → " + j.Device
+		}
+		b.WriteString(StyleInfoDim.Render(route + "  " + string(j.State)))
+		b.WriteString("\n")
+		b.WriteString(" " + StyleInfoLabel.Render("Limit") + "     ")
+		b.WriteString(StyleInfoValue.Render(formatLimit(p.app.deviceLimit(j.Device).Rate())))
+		b.WriteString(StyleInfoDim.Render(", " + formatLimit(p.app.runner.globalLimit.Rate()) + " overall"))
+		if j.Verified != "" {
+			b.WriteString(StyleInfoDim.Render("  verified by " + j.Verified))
+		}
+		if j.Error != "" {
+			b.WriteString("\n            ")
+			b.WriteString(StyleFailBadge.Render(j.Error))
+		}
+		b.WriteString("\n")
+		if current, average := p.app.rates(); average > 0 {
+			b.WriteString(" " + StyleInfoLabel.Render("Speed") + "     ")
+			b.WriteString(StyleInfoValue.Render(formatRate(current) + " now"))
+			b.WriteString(StyleInfoDim.Render(", " + formatRate(average) + " average"))
+			b.WriteString("\n")
+		}
+	}
+
 	if p.app.selectedClient == nil && p.app.selectedConsole == nil {
 		b.WriteString("\n")
 		b.WriteString(StyleHelp.Render(" Select a device and console"))
@@ -75,3 +129,31 @@ func (p *MetadataPanel) View() string {
 		Height(p.height).
 		Render(b.String())
 }
+
+// clientAddress describes where a client is: user@host:port as resolved
+// through ~/.ssh/config, an FTP URL or the mount path of a local one.
+func clientAddress(c *config.Client) string {
+	switch {
+	case c.IsLocal():
+		return "local " + c.ROMDir
+	case c.IsFTP():
+		return fmt.Sprintf("%s://%s@%s:%d", c.Transport, c.User, c.Host, c.Port)
+	}
+	r := c.ResolveSSH()
+	return fmt.Sprintf("%s@%s:%d", r.User, r.Host, r.Port)
+}
+
+// predictPush estimates how long pushing size bytes to the current targets
+// will take from their speed history. Devices run in parallel, so the
+// slowest one decides; targets without history are left out.
+func (p *MetadataPanel) predictPush(size int64) (time.Duration, bool) {
+	var longest time.Duration
+	found := false
+	for _, c := range p.app.transferTargets() {
+		if d, ok := p.app.stats.Estimate(c.Name, size); ok {
+			longest = max(longest, d)
+			found = true
+		}
+	}
+	return longest, found
+}
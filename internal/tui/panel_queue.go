@@ -0,0 +1,246 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"romrepo/internal/queue"
+)
+
+// QueuePanel lists the transfer queue and lets jobs be reordered, retried,
+// cancelled and removed while browsing continues.
+type QueuePanel struct {
+	app    *App
+	cursor int
+	width  int
+	height int
+}
+
+func NewQueuePanel(app *App) QueuePanel {
+	return QueuePanel{app: app}
+}
+
+func (p *QueuePanel) SetSize(w, h int) {
+	p.width = w
+	p.height = h
+}
+
+// SelectedJob returns the job under the cursor.
+func (p *QueuePanel) SelectedJob() *queue.Job {
+	jobs := p.app.queue.Jobs()
+	if p.cursor < 0 || p.cursor >= len(jobs) {
+		return nil
+	}
+	return &jobs[p.cursor]
+}
+
+func (p *QueuePanel) Update(msg tea.KeyMsg) tea.Cmd {
+	q := p.app.queue
+	jobs := q.Jobs()
+	if p.cursor >= len(jobs) {
+		p.cursor = max(0, len(jobs)-1)
+	}
+
+	switch msg.String() {
+	case "up", "k":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+
+	case "down", "j":
+		if p.cursor < len(jobs)-1 {
+			p.cursor++
+		}
+
+	case "K", "shift+up":
+		if j := p.SelectedJob(); j != nil && q.Move(j.ID, -1) {
+			p.cursor--
+			return p.app.saveQueue(nil)
+		}
+
+	case "J", "shift+down":
+		if j := p.SelectedJob(); j != nil && q.Move(j.ID, 1) {
+			p.cursor++
+			return p.app.saveQueue(nil)
+		}
+
+	case "d":
+		if j := p.SelectedJob(); j != nil && q.Remove(j.ID) {
+			return p.app.saveQueue(nil)
+		}
+
+	case "esc", "c":
+		if j := p.SelectedJob(); j != nil {
+			if run := p.app.runner.running(j.ID); run != nil {
+				run.cancel()
+			}
+		}
+
+	case "r":
+		if j := p.SelectedJob(); j != nil && q.Retry(j.ID) {
+			return p.app.saveQueue(p.app.runQueue())
+		}
+
+	case "R":
+		if q.RetryFailed() > 0 {
+			return p.app.saveQueue(p.app.runQueue())
+		}
+
+	case "C":
+		if q.ClearDone() > 0 {
+			return p.app.saveQueue(nil)
+		}
+
+	case "p":
+		return p.app.togglePause()
+
+	case "+", "=", "-":
+		if j := p.SelectedJob(); j != nil {
+			l := p.app.deviceLimit(j.Device)
+			l.SetRate(stepRate(l.Rate(), msg.String() == "-"))
+		}
+
+	case "]", "[":
+		l := p.app.runner.globalLimit
+		l.SetRate(stepRate(l.Rate(), msg.String() == "["))
+	}
+	return nil
+}
+
+func (p *QueuePanel) View(focused bool) string {
+	contentH := max(1, p.height-1)
+	innerW := max(1, p.width-4)
+	jobs := p.app.queue.Jobs()
+
+	var b strings.Builder
+	if len(jobs) == 0 {
+		b.WriteString(StyleHelp.Render(" Nothing queued"))
+	} else {
+		cursor := min(p.cursor, len(jobs)-1)
+
+		// Batch progress heads the list while anything is running.
+		visible := contentH
+		if summary := p.batchSummary(); summary != "" {
+			b.WriteString(" " + StyleInfoDim.Render(truncateEnd(summary, innerW)) + "\n")
+			visible--
+		}
+
+		// The cursor's job takes a second line when it has an error to show.
+		if jobs[cursor].Error != "" {
+			visible--
+		}
+		visible = max(1, visible)
+
+		start := 0
+		if cursor >= visible {
+			start = cursor - visible + 1
+		}
+		end := min(start+visible, len(jobs))
+
+		for i := start; i < end; i++ {
+			j := jobs[i]
+			prefix := "  "
+			if i == cursor && focused {
+				prefix = StyleCursor.Render("▸") + " "
+			}
+
+			icon, status := p.jobStatus(j)
+			label := truncateEnd(status+j.ROM+" → "+j.Device, innerW-2)
+			switch {
+			case i == cursor && focused:
+				label = StyleSelected.Render(label)
+			case j.State == queue.Done || j.State == queue.Skipped:
+				label = StyleInfoDim.Render(label)
+			default:
+				label = StyleInfoValue.Render(label)
+			}
+
+			b.WriteString(prefix + icon + " " + label)
+			if i == cursor && j.Error != "" {
+				b.WriteString("\n    " + StyleInfoDim.Render(truncateEnd(j.Error, innerW-2)))
+			}
+			if i < end-1 {
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	return renderPanel(b.String(), p.title(), focused, p.width, p.height)
+}
+
+// batchSummary describes the running batch's progress, throughput and time
+// left, or returns "" when nothing is running.
+func (p *QueuePanel) batchSummary() string {
+	if len(p.app.runner.runs) == 0 {
+		return ""
+	}
+	done, total := p.app.batchProgress()
+	parts := []string{formatSize(total)}
+	if total > 0 {
+		parts[0] = fmt.Sprintf("%d%% of %s", done*100/total, formatSize(total))
+	}
+	if current, _ := p.app.rates(); current > 0 {
+		parts = append(parts, formatRate(current))
+	}
+	if eta, ok := p.app.batchETA(); ok {
+		parts = append(parts, formatETA(eta)+" left")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// jobStatus returns a job's state icon and any status text to show before
+// its name.
+func (p *QueuePanel) jobStatus(j queue.Job) (string, string) {
+	switch j.State {
+	case queue.Running:
+		status := ""
+		if run := p.app.runner.running(j.ID); run != nil {
+			if tot := run.total.Load(); tot > 0 {
+				status = fmt.Sprintf("%d%% ", run.transferred.Load()*100/tot)
+			}
+		}
+		return StyleSyncBadge.Render("⇡"), status
+	case queue.Done:
+		return StyleSyncBadge.Render("✓"), ""
+	case queue.Skipped:
+		return StyleInfoDim.Render("–"), ""
+	case queue.Failed:
+		return StyleFailBadge.Render("✗"), ""
+	}
+	if j.Error != "" {
+		return StyleUnsyncBadge.Render("…"), ""
+	}
+	return StyleInfoDim.Render("·"), ""
+}
+
+func (p *QueuePanel) title() string {
+	q := p.app.queue
+	var parts []string
+	if n := q.Count(queue.Running); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d running", n))
+	}
+	if n := q.Count(queue.Queued); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d queued", n))
+	}
+	if n := q.Count(queue.Failed); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", n))
+	}
+	if p.app.runner.gate.paused() {
+		parts = append(parts, "paused")
+	}
+	if len(parts) == 0 {
+		return "Queue"
+	}
+	return "Queue (" + strings.Join(parts, ", ") + ")"
+}
+
+// truncateEnd shortens s to at most w runes, marking the cut with an ellipsis.
+func truncateEnd(s string, w int) string {
+	r := []rune(s)
+	if w < 1 || len(r) <= w {
+		return s
+	}
+	return string(r[:w-1]) + "…"
+}
@@ -2,7 +2,6 @@ package tui
 
 import (
 	"fmt"
-	"path/filepath"
 	"sort"
 	"strings"
 	"unicode"
@@ -11,6 +10,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
+	"romrepo/internal/config"
 	"romrepo/internal/remote"
 	"romrepo/internal/rom"
 )
@@ -22,6 +22,7 @@ type ROMPanel struct {
 	cursor    int
 	filterIdx int // 0=ALL, 1=A, ..., 26=Z
 	loading   bool
+	free      uint64          // bytes free on the selected device, 0 if unknown
 	selected  map[string]bool // ROM names toggled for transfer
 	width     int
 	height    int
@@ -45,6 +46,7 @@ func (p *ROMPanel) Clear() {
 	p.cursor = 0
 	p.filterIdx = 0
 	p.loading = false
+	p.free = 0
 	p.selected = make(map[string]bool)
 }
 
@@ -70,6 +72,14 @@ func (p *ROMPanel) LoadROMs() tea.Cmd {
 	p.filtered = nil
 	p.cursor = 0
 
+	// Status across marked devices is computed alongside the selected one.
+	var targets []config.Client
+	if marked := app.devicePanel.MarkedClients(); len(marked) > 1 {
+		for _, c := range marked {
+			targets = append(targets, app.resolvePassword(c))
+		}
+	}
+
 	return func() tea.Msg {
 		if app.selectedConsole == nil || app.selectedClient == nil {
 			return ROMsLoadErrorMsg{Err: fmt.Errorf("no console or client selected")}
@@ -83,53 +93,100 @@ func (p *ROMPanel) LoadROMs() tea.Cmd {
 			return ROMsLoadErrorMsg{Err: fmt.Errorf("listing server ROMs: %w", err)}
 		}
 
-		clientFiles := make(map[string]bool)
-		var clientErr error
-
-		sshConn, err := app.connMgr.Get(client)
-		if err != nil {
-			clientErr = fmt.Errorf("SSH: %w", err)
-		} else {
-			sftpClient, err := remote.NewSFTPClient(sshConn)
-			if err != nil {
-				clientErr = fmt.Errorf("SFTP: %w", err)
-			} else {
-				defer sftpClient.Close()
-
-				clientDir := client.ROMDir
-				if override, ok := client.ConsoleDirs[console.Dir]; ok {
-					clientDir = override
-				} else {
-					clientDir = filepath.Join(client.ROMDir, console.Dir)
-				}
+		clientFiles, clientErr := listClientFiles(app, client, console.Dir)
+		if clientFiles == nil {
+			clientFiles = make(map[string]rom.ClientFile)
+		}
 
-				files, err := sftpClient.ListFiles(clientDir)
-				if err != nil {
-					clientErr = fmt.Errorf("listing %s: %w", clientDir, err)
-				} else {
-					for _, f := range files {
-						clientFiles[f.Name] = true
+		statuses := rom.Diff(serverROMs, clientFiles, app.cfg.CompareMtime)
+		if len(targets) > 0 {
+			// Devices that cannot be listed are left out of the count.
+			var sets []map[string]rom.ClientFile
+			for _, t := range targets {
+				if t.Name == client.Name {
+					if clientErr == nil {
+						sets = append(sets, clientFiles)
 					}
+					continue
+				}
+				if files, err := listClientFiles(app, t, console.Dir); err == nil {
+					sets = append(sets, files)
 				}
 			}
+			rom.CountSynced(statuses, sets)
 		}
 
-		return ROMsLoadedMsg{
-			ROMs:      rom.Diff(serverROMs, clientFiles),
+		msg := ROMsLoadedMsg{
+			ROMs:      statuses,
 			ClientErr: clientErr,
 		}
+		if clientErr == nil {
+			msg.Free = clientFreeSpace(app, client, console.Dir)
+		}
+		return msg
+	}
+}
+
+// listClientDir lists the files in a client's directory for the given console.
+func listClientDir(app *App, client config.Client, consoleDir string) ([]remote.FileInfo, error) {
+	transport, err := app.connMgr.Transport(client, app.cfg.SFTP)
+	if err != nil {
+		return nil, err
+	}
+	defer app.connMgr.Release(client.Name, transport)
+
+	clientDir := client.ConsoleDir(consoleDir)
+
+	// Best effort: a failed cleanup must not hide the listing.
+	_ = transport.CleanupPartials(clientDir, remote.PartialMaxAge)
+
+	files, err := transport.ListFiles(clientDir)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", clientDir, err)
 	}
+	return files, nil
+}
+
+// clientFreeSpace returns the bytes free in a client's console directory,
+// or 0 when the device cannot tell.
+func clientFreeSpace(app *App, client config.Client, consoleDir string) uint64 {
+	transport, err := app.connMgr.Transport(client, app.cfg.SFTP)
+	if err != nil {
+		return 0
+	}
+	defer app.connMgr.Release(client.Name, transport)
+
+	free, err := transport.FreeSpace(client.ConsoleDir(consoleDir))
+	if err != nil {
+		return 0
+	}
+	return free
+}
+
+// listClientFiles returns the files in a client's directory for the given
+// console, keyed by name.
+func listClientFiles(app *App, client config.Client, consoleDir string) (map[string]rom.ClientFile, error) {
+	files, err := listClientDir(app, client, consoleDir)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]rom.ClientFile, len(files))
+	for _, f := range files {
+		byName[f.Name] = rom.ClientFile{Size: f.Size, ModTime: f.ModTime}
+	}
+	return byName, nil
 }
 
 func (p *ROMPanel) HandleLoaded(msg ROMsLoadedMsg) tea.Cmd {
 	p.loading = false
 	sort.Slice(msg.ROMs, func(i, j int) bool {
-		if msg.ROMs[i].Location != msg.ROMs[j].Location {
-			return msg.ROMs[i].Location == rom.OnBoth
+		if a, b := locationRank(msg.ROMs[i].Location), locationRank(msg.ROMs[j].Location); a != b {
+			return a < b
 		}
 		return msg.ROMs[i].Name < msg.ROMs[j].Name
 	})
 	p.roms = msg.ROMs
+	p.free = msg.Free
 	p.cursor = 0
 	p.applyFilter()
 	if msg.ClientErr != nil {
@@ -140,6 +197,18 @@ func (p *ROMPanel) HandleLoaded(msg ROMsLoadedMsg) tea.Cmd {
 	return nil
 }
 
+// locationRank orders the ROM list: synced first, then stale copies, then
+// ROMs only on the server.
+func locationRank(l rom.Location) int {
+	switch l {
+	case rom.OnBoth:
+		return 0
+	case rom.Stale:
+		return 1
+	}
+	return 2
+}
+
 func (p *ROMPanel) HandleLoadError(msg ROMsLoadErrorMsg) tea.Cmd {
 	p.loading = false
 	return func() tea.Msg { return ErrorMsg{Err: msg.Err} }
@@ -177,6 +246,16 @@ func (p *ROMPanel) Update(msg tea.KeyMsg) tea.Cmd {
 	case key.Matches(msg, p.app.keys.Push):
 		return p.startPush()
 
+	case key.Matches(msg, p.app.keys.Sync):
+		return p.startSync()
+
+	case key.Matches(msg, p.app.keys.FixPerms):
+		return p.fixPermissions()
+
+	case key.Matches(msg, p.app.keys.Refresh):
+		if p.app.selectedClient != nil && p.app.selectedConsole != nil {
+			return p.LoadROMs()
+		}
 	}
 
 	return nil
@@ -219,13 +298,62 @@ func (p *ROMPanel) startPush() tea.Cmd {
 	if len(names) == 0 {
 		return nil
 	}
+	clients := p.app.transferTargets()
 	return func() tea.Msg {
 		return TransferStartMsg{
 			ROMNames: names,
+			Clients:  clients,
 		}
 	}
 }
 
+// startSync pushes every server ROM for the console that a target device
+// does not already have.
+func (p *ROMPanel) startSync() tea.Cmd {
+	if p.app.selectedClient == nil || p.app.selectedConsole == nil || len(p.roms) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(p.roms))
+	for _, r := range p.roms {
+		names = append(names, r.Name)
+	}
+	clients := p.app.transferTargets()
+	return func() tea.Msg {
+		return TransferStartMsg{
+			ROMNames: names,
+			Clients:  clients,
+			Sync:     true,
+		}
+	}
+}
+
+// fixPermissions gives the console directory on the selected device, and
+// everything already in it, the device's configured modes and group.
+func (p *ROMPanel) fixPermissions() tea.Cmd {
+	if p.app.selectedClient == nil || p.app.selectedConsole == nil {
+		return nil
+	}
+	app := p.app
+	client := app.resolvePassword(*app.selectedClient)
+	console := app.selectedConsole.Dir
+	if remote.PermissionsOf(client).IsZero() {
+		return func() tea.Msg {
+			return ErrorMsg{Err: fmt.Errorf("%s has no file_mode, dir_mode or group set", client.Name)}
+		}
+	}
+	return func() tea.Msg {
+		t, err := app.connMgr.Transport(client, app.cfg.SFTP)
+		if err != nil {
+			return ErrorMsg{Err: err}
+		}
+		defer app.connMgr.Release(client.Name, t)
+
+		if err := t.FixPermissions(client.ConsoleDir(console)); err != nil {
+			return ErrorMsg{Err: err}
+		}
+		return PermissionsFixedMsg{Client: client.Name, Console: console}
+	}
+}
 
 func (p *ROMPanel) renderFilterBar(w int) string {
 	filters := []string{"ALL"}
@@ -300,6 +428,9 @@ func (p *ROMPanel) ViewBlock(focused bool, w, h int) string {
 			nameW = 1
 		}
 
+		// ROMs waiting in the transfer queue for this device are flagged.
+		pending := p.app.pendingROMs(p.app.selectedClient.Name, p.app.selectedConsole.Dir)
+
 		for i := start; i < end; i++ {
 			r := p.filtered[i]
 			isCursor := i == p.cursor
@@ -311,6 +442,8 @@ func (p *ROMPanel) ViewBlock(focused bool, w, h int) string {
 				style = StyleSelected
 			case r.Location == rom.OnBoth:
 				style = StyleOnBoth
+			case r.Location == rom.Stale:
+				style = StyleStale
 			}
 
 			prefix := "  "
@@ -326,12 +459,25 @@ func (p *ROMPanel) ViewBlock(focused bool, w, h int) string {
 			title := style.Render(r.Name)
 			size := formatSize(r.ServerSize)
 			var status string
-			if r.Location == rom.OnBoth {
+			switch r.Location {
+			case rom.OnBoth:
 				status = StyleSyncBadge.Render("● synced")
-			} else {
+			case rom.Stale:
+				status = StyleStaleBadge.Render("◐ stale")
+			default:
 				status = StyleUnsyncBadge.Render("○ server")
 			}
 			desc := style.Faint(true).Render(size) + "  " + status
+			if r.Targets > 1 {
+				desc += "  " + StyleInfoDim.Render(fmt.Sprintf("%d/%d devices", r.SyncedOn, r.Targets))
+			}
+			if j, ok := pending[r.Name]; ok {
+				label := "⇡ queued"
+				if pct := p.app.runner.jobPercent(j.ID); pct >= 0 {
+					label = fmt.Sprintf("⇡ %d%%", pct)
+				}
+				desc += "  " + StyleUnsyncBadge.Render(label)
+			}
 
 			b.WriteString(prefix + check + wrapWithIndent(title, nameW-4, 6) + "\n")
 			b.WriteString("      " + wrapWithIndent(desc, nameW-4, 6))
@@ -351,6 +497,20 @@ func (p *ROMPanel) ViewBlock(focused bool, w, h int) string {
 	return lipgloss.NewStyle().Width(w).Height(h).MaxHeight(h).Render(b.String())
 }
 
+// SelectedSize returns how many ROMs are selected for transfer and their
+// combined size on the server.
+func (p *ROMPanel) SelectedSize() (int, int64) {
+	var n int
+	var size int64
+	for _, r := range p.roms {
+		if p.selected[r.Name] {
+			n++
+			size += r.ServerSize
+		}
+	}
+	return n, size
+}
+
 // SelectedROM returns the currently highlighted ROM from the filtered list.
 func (p *ROMPanel) SelectedROM() *rom.ROMStatus {
 	if p.cursor >= 0 && p.cursor < len(p.filtered) {
@@ -0,0 +1,1045 @@
+package tui
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"romrepo/internal/config"
+	"romrepo/internal/queue"
+	"romrepo/internal/remote"
+	"romrepo/internal/rom"
+)
+
+// queueRetryDelay is how long a device that could not be reached is left
+// alone before its queued jobs are tried again.
+const queueRetryDelay = 30 * time.Second
+
+type queueTickMsg time.Time
+
+// queueRetryMsg wakes the runner once an unreachable device's wait is over.
+type queueRetryMsg struct{}
+
+// errCancelled is recorded for a job cancelled while it ran.
+var errCancelled = errors.New("cancelled")
+
+// pauseGate blocks transfers while paused. The channel is non-nil while
+// paused and closed on resume.
+type pauseGate struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ch == nil {
+		g.ch = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ch != nil {
+		close(g.ch)
+		g.ch = nil
+	}
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ch != nil
+}
+
+// wait blocks until the gate is resumed or ctx is cancelled.
+func (g *pauseGate) wait(ctx context.Context) {
+	g.mu.Lock()
+	ch := g.ch
+	g.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case <-ch:
+	case <-ctx.Done():
+	}
+}
+
+// rateWindow is how far back the current throughput is measured.
+const rateWindow = 5 * time.Second
+
+// jobRun is a queue job in flight. The counters are written from the
+// transfer goroutine and read by View.
+type jobRun struct {
+	job         queue.Job
+	batch       int // first job of the tar stream this job is sent in, or 0
+	transferred atomic.Int64
+	total       atomic.Int64
+	cancel      context.CancelFunc
+}
+
+// Small ROMs queued together for one device and console are sent as a
+// single tar stream rather than one SFTP upload each.
+const (
+	tarMaxFile  = 8 << 20 // larger ROMs always go over SFTP
+	tarMinJobs  = 4
+	tarMaxJobs  = 256
+	tarMaxBytes = 256 << 20
+)
+
+// deltaMinSize is the smallest ROM already on a device that is updated by
+// delta transfer; smaller ones are cheaper to send whole than to sign.
+const deltaMinSize = 1 << 20
+
+// rateSample is the batch's byte count at a point in time.
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// queueRunner executes queued jobs in the background, up to each device's
+// concurrency at a time and devices in parallel. All fields are owned by
+// App.Update.
+type queueRunner struct {
+	runs     map[int]*jobRun      // running jobs by ID
+	waiting  map[string]time.Time // unreachable devices, until when
+	swept    map[string]bool      // device directories cleared of stale partials
+	noTar    map[string]bool      // devices found without a tar command
+	noDelta  map[string]bool      // devices found without the delta helper
+	declined map[string]bool      // clients whose credential prompt was dismissed
+	ticking  bool
+	gate     pauseGate
+
+	// A batch runs from the first job started while idle until the queue
+	// has nothing left to run.
+	batchStart time.Time
+	batchDone  int64 // bytes of jobs finished in this batch
+	samples    []rateSample
+
+	// Limits start from the config and can be changed while jobs run.
+	globalLimit  *remote.RateLimiter
+	deviceLimits map[string]*remote.RateLimiter
+}
+
+func newQueueRunner() *queueRunner {
+	return &queueRunner{
+		runs:         make(map[int]*jobRun),
+		waiting:      make(map[string]time.Time),
+		swept:        make(map[string]bool),
+		noTar:        make(map[string]bool),
+		noDelta:      make(map[string]bool),
+		declined:     make(map[string]bool),
+		globalLimit:  remote.NewRateLimiter(0),
+		deviceLimits: make(map[string]*remote.RateLimiter),
+	}
+}
+
+// rateSteps are the limits "+" and "-" step through, fastest first; 0 is
+// unlimited.
+var rateSteps = []int64{0, 100 << 20, 50 << 20, 20 << 20, 10 << 20, 5 << 20, 2 << 20, 1 << 20, 512 << 10, 256 << 10, 128 << 10}
+
+// stepRate returns the next limit after cur, slower or faster.
+func stepRate(cur int64, slower bool) int64 {
+	i := 0
+	if cur > 0 {
+		i = len(rateSteps) - 1
+		for j, step := range rateSteps[1:] {
+			if step <= cur {
+				i = j + 1
+				break
+			}
+		}
+		// A limit between steps moves to the nearest step first.
+		if rateSteps[i] != cur && !slower {
+			return rateSteps[i]
+		}
+	}
+	if slower {
+		return rateSteps[min(i+1, len(rateSteps)-1)]
+	}
+	return rateSteps[max(i-1, 0)]
+}
+
+// applyRateLimits resets every limiter to the configured value.
+func (a *App) applyRateLimits() {
+	r := a.runner
+	global, _ := config.ParseRate(a.cfg.RateLimit)
+	r.globalLimit.SetRate(global)
+	for name, l := range r.deviceLimits {
+		rate := int64(0)
+		if c, ok := a.clientByName(name); ok {
+			rate, _ = config.ParseRate(c.RateLimit)
+		}
+		l.SetRate(rate)
+	}
+}
+
+// deviceLimit returns the limiter for a device, creating it from the config
+// on first use.
+func (a *App) deviceLimit(device string) *remote.RateLimiter {
+	r := a.runner
+	if l, ok := r.deviceLimits[device]; ok {
+		return l
+	}
+	rate := int64(0)
+	if c, ok := a.clientByName(device); ok {
+		rate, _ = config.ParseRate(c.RateLimit)
+	}
+	l := remote.NewRateLimiter(rate)
+	r.deviceLimits[device] = l
+	return l
+}
+
+// formatLimit renders a rate limit, which is 0 when unlimited.
+func formatLimit(bps int64) string {
+	if bps <= 0 {
+		return "unlimited"
+	}
+	return formatRate(float64(bps))
+}
+
+// running returns the run for a job, if it is in flight.
+func (r *queueRunner) running(id int) *jobRun {
+	return r.runs[id]
+}
+
+// deviceRuns returns how many transfers are running on a device, counting
+// a tar stream once however many jobs it carries.
+func (r *queueRunner) deviceRuns(device string) int {
+	n := 0
+	for _, run := range r.runs {
+		if run.job.Device == device && (run.batch == 0 || run.batch == run.job.ID) {
+			n++
+		}
+	}
+	return n
+}
+
+// enqueue adds a job per ROM and target device and starts any that can run.
+func (a *App) enqueue(msg TransferStartMsg) tea.Cmd {
+	if a.selectedConsole == nil {
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("no console selected")} }
+	}
+	var jobs []queue.Job
+	for _, c := range msg.Clients {
+		for _, name := range msg.ROMNames {
+			j := queue.Job{
+				Device:       c.Name,
+				Console:      a.selectedConsole.Dir,
+				ROM:          name,
+				SkipExisting: msg.Sync,
+			}
+			if msg.Source != nil {
+				j.Source = msg.Source.Name
+			} else if info, err := os.Stat(filepath.Join(a.cfg.Server.ROMDir, j.Console, name)); err == nil {
+				j.Size = info.Size()
+			}
+			jobs = append(jobs, j)
+		}
+	}
+	if a.queue.Add(jobs...) == 0 {
+		return func() tea.Msg { return ErrorMsg{Err: fmt.Errorf("already queued")} }
+	}
+	return a.saveQueue(a.runQueue())
+}
+
+// saveQueue persists the queue, reporting a failure alongside cmd.
+func (a *App) saveQueue(cmd tea.Cmd) tea.Cmd {
+	if err := a.queue.Save(); err != nil {
+		return tea.Batch(cmd, func() tea.Msg { return ErrorMsg{Err: err} })
+	}
+	return cmd
+}
+
+// runQueue starts queued jobs on every device that is reachable, has its
+// credentials and is running fewer than its concurrency allows. Jobs for
+// devices needing a password or passphrase wait, marked as such, until one
+// is entered.
+func (a *App) runQueue() tea.Cmd {
+	r := a.runner
+	if r.gate.paused() {
+		return nil
+	}
+
+	var cmds []tea.Cmd
+	for _, dev := range a.queue.Devices() {
+		if time.Now().Before(r.waiting[dev]) {
+			continue
+		}
+		cmds = append(cmds, a.runDevice(dev)...)
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	if !r.ticking {
+		r.ticking = true
+		cmds = append(cmds, queueTick())
+	}
+	return a.saveQueue(tea.Batch(cmds...))
+}
+
+// runDevice starts queued jobs on one device until it is running as many
+// as its concurrency allows.
+func (a *App) runDevice(dev string) []tea.Cmd {
+	r := a.runner
+	var cmds []tea.Cmd
+	for {
+		job, ok := a.queue.Next(dev)
+		if !ok {
+			return cmds
+		}
+
+		client, ok := a.clientByName(job.Device)
+		if !ok {
+			a.queue.Start(job.ID)
+			a.queue.Finish(job.ID, "", fmt.Errorf("device %q is no longer configured", job.Device))
+			continue
+		}
+		var source *config.Client
+		if job.Source != "" {
+			src, ok := a.clientByName(job.Source)
+			if !ok {
+				a.queue.Start(job.ID)
+				a.queue.Finish(job.ID, "", fmt.Errorf("device %q is no longer configured", job.Source))
+				continue
+			}
+			source = &src
+		}
+		clients := []config.Client{client}
+		if source != nil {
+			clients = append(clients, *source)
+		}
+		if cmd, ok := a.checkKeys(nil, clients...); !ok {
+			a.holdDevice(dev, "checking SSH keys")
+			if cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+			return cmds
+		}
+		var locked *config.Client
+		switch {
+		case a.needsPassword(&client):
+			locked = &client
+		case source != nil && a.needsPassword(source):
+			locked = source
+		}
+		if locked != nil {
+			if cmd := a.awaitCredentials(dev, locked); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+			return cmds
+		}
+		if r.deviceRuns(dev) >= client.Transfers() {
+			return cmds
+		}
+
+		client = a.resolvePassword(client)
+		if source != nil {
+			src := a.resolvePassword(*source)
+			source = &src
+		}
+
+		if r.batchStart.IsZero() {
+			r.batchStart = time.Now()
+			r.batchDone = 0
+			r.samples = nil
+		}
+
+		// Partial files left by earlier sessions are swept once per
+		// directory rather than listing it again for every ROM.
+		sweep := dev + "\x00" + job.Console
+		cleanup := !r.swept[sweep]
+		r.swept[sweep] = true
+
+		if batch := a.tarBatch(client, job); batch != nil {
+			cmds = append(cmds, a.startTar(batch, client, cleanup))
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		run := &jobRun{job: job, cancel: cancel}
+		r.runs[job.ID] = run
+		a.queue.Start(job.ID)
+		cmds = append(cmds, a.runJob(ctx, run, client, source, a.deviceLimit(dev), cleanup))
+	}
+}
+
+// awaitCredentials marks a device's queued jobs as waiting for c's password
+// or key passphrase and asks for it, unless another dialog is open or the
+// user has already dismissed the prompt for c.
+func (a *App) awaitCredentials(dev string, c *config.Client) tea.Cmd {
+	what := "key passphrase"
+	if a.passwordMissing(c) {
+		what = "password"
+	}
+	if c.Name != dev {
+		what = c.Name + " " + what
+	}
+	a.holdDevice(dev, "waiting for "+what)
+
+	if a.mode != ModeNormal || a.overlay != nil || a.runner.declined[c.Name] {
+		return nil
+	}
+	a.pendingAction.kind = pendingQueue
+	a.pendingAction.device = c.Name
+	a.mode = ModePassword
+	a.overlay = a.credentialPrompt(c)
+	return a.overlay.Init()
+}
+
+// holdDevice gives the reason a device's queued jobs are not starting.
+func (a *App) holdDevice(dev, reason string) {
+	for _, j := range a.queue.Jobs() {
+		if j.Device == dev && j.State == queue.Queued {
+			a.queue.Requeue(j.ID, reason)
+		}
+	}
+}
+
+func queueTick() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
+		return queueTickMsg(t)
+	})
+}
+
+// clientByName returns the configured client with the given name.
+func (a *App) clientByName(name string) (config.Client, bool) {
+	for _, c := range a.cfg.Clients {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return config.Client{}, false
+}
+
+// runJob pushes one ROM to one device.
+func (a *App) runJob(ctx context.Context, run *jobRun, client config.Client, source *config.Client, limit *remote.RateLimiter, cleanup bool) tea.Cmd {
+	app := a
+	job := run.job
+	gate := &a.runner.gate
+	globalLimit := a.runner.globalLimit
+	sftpOpts := a.cfg.SFTP
+	compareTimes := a.cfg.CompareMtime
+	tryDelta := client.IsSSH() && client.Delta != "off" && !a.runner.noDelta[job.Device]
+
+	return func() tea.Msg {
+		var sent int64
+		var elapsed time.Duration
+		var noDelta bool
+		done := func(verified string, err error) tea.Msg {
+			return QueueJobDoneMsg{JobID: job.ID, Device: job.Device, Verified: verified, Err: err, Bytes: sent, Elapsed: elapsed, NoDelta: noDelta}
+		}
+		unreachable := func(err error) tea.Msg {
+			return QueueJobDoneMsg{JobID: job.ID, Device: job.Device, Err: err, Unreachable: true}
+		}
+
+		target, err := app.connMgr.Transport(client, sftpOpts)
+		if err != nil {
+			return unreachable(err)
+		}
+		defer app.connMgr.Release(client.Name, target)
+		target.SetRateLimits(globalLimit, limit)
+
+		// When copying between devices the ROM is streamed from the source
+		// device without touching the server.
+		var origin remote.Transport
+		if source != nil {
+			origin, err = app.connMgr.Transport(*source, sftpOpts)
+			if err != nil {
+				return unreachable(fmt.Errorf("%s: %w", source.Name, err))
+			}
+			defer app.connMgr.Release(source.Name, origin)
+		}
+
+		clientDir := client.ConsoleDir(job.Console)
+		clientPath := filepath.Join(clientDir, job.ROM)
+		if cleanup {
+			_ = target.CleanupPartials(clientDir, remote.PartialMaxAge)
+		}
+
+		serverPath := filepath.Join(app.cfg.Server.ROMDir, job.Console, job.ROM)
+		if job.SkipExisting {
+			if info, err := target.Stat(clientPath); err == nil && !(compareTimes && staleCopy(serverPath, info)) {
+				return QueueJobDoneMsg{JobID: job.ID, Device: job.Device, Skipped: "already on device"}
+			}
+		}
+
+		// Blocking here stalls the copy while the queue is paused. The
+		// first call reports any data already on the device from an
+		// interrupted push, which is not counted as sent.
+		base := int64(-1)
+		progressFn := func(t, tot int64) {
+			if base < 0 {
+				base = t
+			}
+			sent = t - base
+			run.transferred.Store(t)
+			run.total.Store(tot)
+			gate.wait(ctx)
+		}
+
+		var verified string
+		start := time.Now()
+		if origin != nil {
+			srcPath := filepath.Join(source.ConsoleDir(job.Console), job.ROM)
+			err = remote.Copy(ctx, origin, target, srcPath, clientPath, progressFn)
+			elapsed = time.Since(start)
+			if err == nil {
+				if info, statErr := origin.Stat(srcPath); statErr == nil {
+					keepModTime(target, clientPath, info.ModTime)
+				}
+				verified, err = verifyCopy(client, target, origin, srcPath, clientPath)
+			}
+		} else {
+			wire := int64(-1)
+			if sh, ok := target.(remote.Shell); ok && tryDelta && deltaWorthwhile(target, clientPath, job.Size) {
+				helper := cmp.Or(client.DeltaHelper, remote.DefaultDeltaHelper)
+				wire, err = sh.PushDelta(ctx, serverPath, clientPath, helper, progressFn)
+				noDelta = errors.Is(err, remote.ErrNoDelta)
+				if err != nil && !errors.Is(err, context.Canceled) && !remote.IsConnectionLost(err) {
+					// Any other failure falls back to a full push.
+					wire, err, base = -1, nil, -1
+				}
+			}
+			// Resume falls back to a full push when there is no partial
+			// file, so a job interrupted by a restart picks up where it was.
+			if wire < 0 && err == nil {
+				err = target.Resume(ctx, serverPath, clientPath, progressFn)
+			}
+			elapsed = time.Since(start)
+			if wire >= 0 {
+				// Only what went over the wire counts towards speed history.
+				sent = wire
+			}
+			if err == nil {
+				if info, statErr := os.Stat(serverPath); statErr == nil {
+					keepModTime(target, clientPath, info.ModTime())
+				}
+				verified, err = verifyPush(client, target, serverPath, clientPath)
+			}
+		}
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			return done("", errCancelled)
+		case remote.IsConnectionLost(err):
+			return unreachable(err)
+		}
+		return done(verified, err)
+	}
+}
+
+// staleCopy reports whether a device file differs from the server ROM at
+// serverPath in size or modification time.
+func staleCopy(serverPath string, info remote.FileInfo) bool {
+	local, err := os.Stat(serverPath)
+	if err != nil {
+		return false
+	}
+	return rom.IsStale(rom.ROMFile{Size: local.Size(), ModTime: local.ModTime()}, rom.ClientFile{Size: info.Size, ModTime: info.ModTime})
+}
+
+// keepModTime gives a pushed ROM the modification time of its original, so
+// later listings can tell current copies from stale ones. Best effort:
+// devices that cannot set times keep the upload time.
+func keepModTime(t remote.Transport, path string, mtime time.Time) {
+	if !mtime.IsZero() {
+		_ = t.Chtimes(path, mtime)
+	}
+}
+
+// deltaWorthwhile reports whether a ROM being pushed to path should be sent
+// as a delta against the copy already there: the device must hold a
+// complete file of some size, and no partial file that Resume would use.
+func deltaWorthwhile(t remote.Transport, path string, size int64) bool {
+	if size < deltaMinSize {
+		return false
+	}
+	info, err := t.Stat(path)
+	if err != nil || info.IsDir || info.Size < deltaMinSize {
+		return false
+	}
+	return !t.FileExists(remote.PartialPath(path))
+}
+
+// tarBatch returns the queued jobs to send with job as one tar stream, or
+// nil when job should go over SFTP on its own. Clients not reached over SSH
+// cannot unpack a stream and always get nil.
+func (a *App) tarBatch(client config.Client, job queue.Job) []queue.Job {
+	eligible := func(j queue.Job) bool {
+		return j.State == queue.Queued && j.Device == job.Device && j.Console == job.Console &&
+			j.Source == "" && j.Size > 0 && j.Size <= tarMaxFile
+	}
+	if !client.IsSSH() || client.Tar == "off" || a.runner.noTar[job.Device] || !eligible(job) {
+		return nil
+	}
+
+	var batch []queue.Job
+	var bytes int64
+	for _, j := range a.queue.Jobs() {
+		if !eligible(j) {
+			continue
+		}
+		if len(batch) == tarMaxJobs || bytes+j.Size > tarMaxBytes {
+			break
+		}
+		batch = append(batch, j)
+		bytes += j.Size
+	}
+	if len(batch) < tarMinJobs {
+		return nil
+	}
+	return batch
+}
+
+// startTar marks a batch of jobs running and sends them as one tar stream.
+// Cancelling any of them stops the whole stream.
+func (a *App) startTar(batch []queue.Job, client config.Client, cleanup bool) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := func(j queue.Job) *jobRun {
+		run := &jobRun{job: j, batch: batch[0].ID, cancel: cancel}
+		a.runner.runs[j.ID] = run
+		a.queue.Start(j.ID)
+		return run
+	}
+	runs := make([]*jobRun, len(batch))
+	for i, j := range batch {
+		runs[i] = start(j)
+	}
+	return a.runTar(ctx, runs, client, a.deviceLimit(client.Name), cleanup)
+}
+
+// runTar sends a batch of small ROMs from the server as one tar stream,
+// reporting each job's outcome separately.
+func (a *App) runTar(ctx context.Context, runs []*jobRun, client config.Client, limit *remote.RateLimiter, cleanup bool) tea.Cmd {
+	app := a
+	gate := &a.runner.gate
+	globalLimit := a.runner.globalLimit
+	sftpOpts := a.cfg.SFTP
+	compareTimes := a.cfg.CompareMtime
+	console := runs[0].job.Console
+	serverDir := filepath.Join(a.cfg.Server.ROMDir, console)
+
+	return func() tea.Msg {
+		msgs := make([]QueueJobDoneMsg, len(runs))
+		for i, run := range runs {
+			msgs[i] = QueueJobDoneMsg{JobID: run.job.ID, Device: run.job.Device}
+		}
+		finish := func() tea.Msg {
+			var cmds tea.BatchMsg
+			for _, msg := range msgs {
+				cmds = append(cmds, func() tea.Msg { return msg })
+			}
+			return cmds
+		}
+		failAll := func(err error, unreachable bool) tea.Msg {
+			for i := range msgs {
+				if msgs[i].Skipped == "" {
+					msgs[i].Err = err
+					msgs[i].Unreachable = unreachable
+				}
+			}
+			return finish()
+		}
+
+		target, err := app.connMgr.Transport(client, sftpOpts)
+		if err != nil {
+			return failAll(err, true)
+		}
+		defer app.connMgr.Release(client.Name, target)
+		target.SetRateLimits(globalLimit, limit)
+
+		clientDir := client.ConsoleDir(console)
+		if cleanup {
+			_ = target.CleanupPartials(clientDir, remote.PartialMaxAge)
+		}
+
+		// A sync leaves ROMs the device already has, found with one listing
+		// rather than a lookup per file.
+		present := make(map[string]remote.FileInfo)
+		for _, run := range runs {
+			if !run.job.SkipExisting {
+				continue
+			}
+			files, err := target.ListFiles(clientDir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return failAll(err, remote.IsConnectionLost(err))
+			}
+			for _, f := range files {
+				present[f.Name] = f
+			}
+			break
+		}
+
+		var files []remote.TarFile
+		var sending []int
+		var total int64
+		for i, run := range runs {
+			localPath := filepath.Join(serverDir, run.job.ROM)
+			if info, ok := present[run.job.ROM]; run.job.SkipExisting && ok && !(compareTimes && staleCopy(localPath, info)) {
+				msgs[i].Skipped = "already on device"
+				continue
+			}
+			files = append(files, remote.TarFile{LocalPath: localPath, Name: run.job.ROM})
+			sending = append(sending, i)
+			total += run.job.Size
+		}
+		if len(files) == 0 {
+			return finish()
+		}
+
+		start := time.Now()
+		sh, ok := target.(remote.Shell)
+		if !ok {
+			err = remote.ErrNoTar
+		} else {
+			err = sh.PushTar(ctx, clientDir, files, func(i int, t, tot int64) {
+				run := runs[sending[i]]
+				run.transferred.Store(t)
+				run.total.Store(tot)
+				gate.wait(ctx)
+			})
+		}
+		elapsed := time.Since(start)
+		switch {
+		case errors.Is(err, remote.ErrNoTar):
+			for _, i := range sending {
+				msgs[i].NoTar = true
+			}
+			return finish()
+		case errors.Is(err, context.Canceled):
+			return failAll(errCancelled, false)
+		case remote.IsConnectionLost(err):
+			return failAll(err, true)
+		case err != nil:
+			return failAll(err, false)
+		}
+
+		results := verifyTar(client, target, sh, clientDir, files)
+		for k, i := range sending {
+			size := runs[i].job.Size
+			msgs[i].Verified, msgs[i].Err = results[k].verified, results[k].err
+			msgs[i].Bytes = size
+			if total > 0 {
+				msgs[i].Elapsed = time.Duration(float64(elapsed) * float64(size) / float64(total))
+			}
+		}
+		return finish()
+	}
+}
+
+// tarResult is how one file of a tar stream was verified.
+type tarResult struct {
+	verified string
+	err      error
+}
+
+// verifyTar checks the files of a tar stream against the server, hashing
+// them on the device with one command where it can. Without a checksum
+// command each file is verified like a single push.
+func verifyTar(client config.Client, t remote.Transport, sh remote.Shell, dir string, files []remote.TarFile) []tarResult {
+	results := make([]tarResult, len(files))
+	if client.Verify == "off" {
+		return results
+	}
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	algo, method, sums, err := sh.Checksums(dir, names)
+	if errors.Is(err, remote.ErrNoChecksumCommand) {
+		for i, f := range files {
+			results[i].verified, results[i].err = verifyPush(client, t, f.LocalPath, filepath.Join(dir, f.Name))
+		}
+		return results
+	}
+
+	for i, f := range files {
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		local, lErr := remote.LocalChecksum(f.LocalPath, algo)
+		switch {
+		case lErr != nil:
+			results[i].err = lErr
+		case sums[f.Name] != local:
+			results[i] = tarResult{method, fmt.Errorf("%s %s: %w", algo, method, remote.ErrChecksumMismatch)}
+		default:
+			results[i].verified = method
+		}
+	}
+	return results
+}
+
+// finishJob records a job's outcome and starts whatever can run next.
+func (a *App) finishJob(msg QueueJobDoneMsg) tea.Cmd {
+	r := a.runner
+	if run := r.runs[msg.JobID]; run != nil {
+		run.cancel()
+		delete(r.runs, msg.JobID)
+		// The bytes stay in the batch total whatever the outcome, so the
+		// progress shown never goes backwards.
+		r.batchDone += run.transferred.Load()
+	}
+
+	if msg.NoDelta {
+		r.noDelta[msg.Device] = true
+	}
+
+	var cmds []tea.Cmd
+	switch {
+	case msg.NoTar:
+		r.noTar[msg.Device] = true
+		a.queue.Requeue(msg.JobID, "")
+	case a.notePasswordNeeded(msg.Err) != "":
+		// Requeued, the job asks for the password when the queue next runs.
+		a.queue.Requeue(msg.JobID, "")
+	case msg.Unreachable:
+		a.queue.Requeue(msg.JobID, msg.Err.Error())
+		r.waiting[msg.Device] = time.Now().Add(queueRetryDelay)
+		cmds = append(cmds, tea.Tick(queueRetryDelay, func(time.Time) tea.Msg { return queueRetryMsg{} }))
+	case msg.Skipped != "":
+		a.queue.Skip(msg.JobID, msg.Skipped)
+	default:
+		a.queue.Finish(msg.JobID, msg.Verified, msg.Err)
+		if msg.Err == nil && msg.Bytes > 0 {
+			a.stats.Record(msg.Device, msg.Bytes, msg.Elapsed)
+			if err := a.stats.Save(); err != nil {
+				cmds = append(cmds, func() tea.Msg { return ErrorMsg{Err: err} })
+			}
+		}
+	}
+	cmds = append(cmds, a.runQueue())
+	return a.saveQueue(tea.Batch(cmds...))
+}
+
+// batchProgress returns the bytes transferred in the current batch and the
+// total the batch will move once every queued job has run.
+func (a *App) batchProgress() (done, total int64) {
+	r := a.runner
+	done, total = r.batchDone, r.batchDone
+	for _, j := range a.queue.Jobs() {
+		switch j.State {
+		case queue.Running:
+			if run := r.running(j.ID); run != nil {
+				done += run.transferred.Load()
+				total += max(j.Size, run.total.Load())
+			}
+		case queue.Queued:
+			total += j.Size
+		}
+	}
+	return done, total
+}
+
+// sampleRate records the batch's progress for throughput, ending the batch
+// once nothing is running.
+func (a *App) sampleRate(now time.Time) {
+	r := a.runner
+	if len(r.runs) == 0 {
+		r.batchStart = time.Time{}
+		r.samples = nil
+		clear(r.swept)
+		return
+	}
+	done, _ := a.batchProgress()
+	r.samples = append(r.samples, rateSample{at: now, bytes: done})
+	for len(r.samples) > 1 && now.Sub(r.samples[0].at) > rateWindow {
+		r.samples = r.samples[1:]
+	}
+}
+
+// rates returns the current throughput, over the last rateWindow, and the
+// average since the batch started, both in bytes per second.
+func (a *App) rates() (current, average float64) {
+	r := a.runner
+	if n := len(r.samples); n > 1 {
+		first, last := r.samples[0], r.samples[n-1]
+		if dt := last.at.Sub(first.at).Seconds(); dt > 0 {
+			current = float64(last.bytes-first.bytes) / dt
+		}
+	}
+	if !r.batchStart.IsZero() {
+		done, _ := a.batchProgress()
+		if dt := time.Since(r.batchStart).Seconds(); dt > 0 {
+			average = float64(done) / dt
+		}
+	}
+	return current, average
+}
+
+// batchETA estimates the time left in the batch at the current throughput.
+func (a *App) batchETA() (time.Duration, bool) {
+	current, average := a.rates()
+	rate := current
+	if rate <= 0 {
+		rate = average
+	}
+	done, total := a.batchProgress()
+	if rate <= 0 || total <= done {
+		return 0, false
+	}
+	return time.Duration(float64(total-done) / rate * float64(time.Second)), true
+}
+
+// formatRate renders a throughput in bytes per second.
+func formatRate(bps float64) string {
+	return formatSize(int64(bps)) + "/s"
+}
+
+// formatETA renders a remaining time to the second, or the minute once it
+// runs past an hour.
+func formatETA(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// reloadAfterJob refreshes the ROM list once the last pending job for the
+// console being browsed has finished on the selected or a marked device.
+func (a *App) reloadAfterJob(job queue.Job) tea.Cmd {
+	if a.selectedClient == nil || a.selectedConsole == nil || job.Console != a.selectedConsole.Dir {
+		return nil
+	}
+	shown := job.Device == a.selectedClient.Name
+	for _, c := range a.devicePanel.MarkedClients() {
+		shown = shown || c.Name == job.Device
+	}
+	if !shown {
+		return nil
+	}
+	for _, j := range a.queue.Jobs() {
+		if j.Device == job.Device && j.Console == job.Console && (j.State == queue.Queued || j.State == queue.Running) {
+			return nil
+		}
+	}
+	return a.romPanel.LoadROMs()
+}
+
+// pendingROMs returns the ROMs queued or running for a device and console.
+func (a *App) pendingROMs(device, console string) map[string]queue.Job {
+	pending := make(map[string]queue.Job)
+	for _, j := range a.queue.Jobs() {
+		if j.Device == device && j.Console == console && (j.State == queue.Queued || j.State == queue.Running) {
+			pending[j.ROM] = j
+		}
+	}
+	return pending
+}
+
+// jobPercent returns how far a running job has got, or -1 before its size
+// is known.
+func (r *queueRunner) jobPercent(id int) int64 {
+	run := r.running(id)
+	if run == nil {
+		return -1
+	}
+	tot := run.total.Load()
+	if tot == 0 {
+		return -1
+	}
+	return run.transferred.Load() * 100 / tot
+}
+
+// queueStatus summarises background transfers for the status bar, or
+// returns "" when the queue is idle.
+func (a *App) queueStatus() string {
+	r := a.runner
+	var parts []string
+
+	switch len(r.runs) {
+	case 0:
+	case 1:
+		for _, run := range r.runs {
+			label := truncateEnd(run.job.ROM, 24) + " → " + run.job.Device
+			if pct := r.jobPercent(run.job.ID); pct >= 0 {
+				label += fmt.Sprintf(" %d%%", pct)
+			}
+			parts = append(parts, StyleSyncBadge.Render("⇡ ")+label)
+		}
+	default:
+		var cur, tot int64
+		for _, run := range r.runs {
+			cur += run.transferred.Load()
+			tot += run.total.Load()
+		}
+		label := fmt.Sprintf("%d transfers", len(r.runs))
+		if tot > 0 {
+			label += fmt.Sprintf(" %d%%", cur*100/tot)
+		}
+		parts = append(parts, StyleSyncBadge.Render("⇡ ")+label)
+	}
+
+	if len(r.runs) > 0 {
+		if eta, ok := a.batchETA(); ok {
+			parts = append(parts, "ETA "+formatETA(eta))
+		}
+	}
+	if n := a.queue.Count(queue.Queued); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d queued", n))
+	}
+	if limit := r.globalLimit.Rate(); limit > 0 && len(parts) > 0 {
+		parts = append(parts, "≤ "+formatRate(float64(limit)))
+	}
+	if n := a.queue.Count(queue.Failed); n > 0 {
+		parts = append(parts, StyleFailBadge.Render(fmt.Sprintf("%d failed", n)))
+	}
+	if len(parts) > 0 && r.gate.paused() {
+		parts = append([]string{StyleUnsyncBadge.Render("paused")}, parts...)
+	}
+	return strings.Join(parts, StyleHintSep.Render(" · "))
+}
+
+// togglePause holds or releases every running transfer; nothing new starts
+// while paused.
+func (a *App) togglePause() tea.Cmd {
+	if a.runner.gate.paused() {
+		a.runner.gate.resume()
+		return a.runQueue()
+	}
+	a.runner.gate.pause()
+	return nil
+}
+
+// verifyPush checks a pushed ROM according to the client's verify setting.
+// In "hash" mode a device without a checksum command is reported as
+// unverified rather than failed.
+func verifyPush(client config.Client, t remote.Transport, localPath, remotePath string) (string, error) {
+	if client.Verify == "off" {
+		return "", nil
+	}
+	method, err := remote.Verify(t, localPath, remotePath, client.Verify != "hash")
+	return unverified(method, err)
+}
+
+// verifyCopy checks a ROM copied between devices like verifyPush.
+func verifyCopy(client config.Client, dst, src remote.Transport, srcPath, dstPath string) (string, error) {
+	if client.Verify == "off" {
+		return "", nil
+	}
+	method, err := remote.VerifyCopy(dst, src, srcPath, dstPath, client.Verify != "hash")
+	return unverified(method, err)
+}
+
+// unverified maps "no way to hash this file" to an unverified success.
+// Any other error, a missing file included, fails the job.
+func unverified(method string, err error) (string, error) {
+	if errors.Is(err, remote.ErrNoChecksumCommand) {
+		return "", nil
+	}
+	return method, err
+}
@@ -9,6 +9,7 @@ var (
 	colorCyan      = lipgloss.Color("81")
 	colorGreen     = lipgloss.Color("78")
 	colorRed       = lipgloss.Color("196")
+	colorAmber     = lipgloss.Color("214")
 	colorWhite     = lipgloss.Color("15")
 	colorLightGrey = lipgloss.Color("252")
 	colorGrey      = lipgloss.Color("245")
@@ -46,6 +47,9 @@ var (
 	StyleServerOnly = lipgloss.NewStyle().
 			Foreground(colorDimGrey)
 
+	StyleStale = lipgloss.NewStyle().
+			Foreground(colorAmber)
+
 	StyleHelp = lipgloss.NewStyle().
 			Foreground(colorFaintGrey).
 			Italic(true).
@@ -119,4 +123,10 @@ var (
 
 	StyleUnsyncBadge = lipgloss.NewStyle().
 				Foreground(colorDimGrey)
+
+	StyleStaleBadge = lipgloss.NewStyle().
+			Foreground(colorAmber)
+
+	StyleFailBadge = lipgloss.NewStyle().
+			Foreground(colorRed)
 )
@@ -8,11 +8,23 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 
 	"romrepo/internal/config"
+	"romrepo/internal/delta"
+	"romrepo/internal/queue"
 	"romrepo/internal/remote"
+	"romrepo/internal/stats"
 	"romrepo/internal/tui"
 )
 
 func main() {
+	// Devices with romrepo installed run it as the delta transfer helper.
+	if len(os.Args) > 1 && os.Args[1] == "delta" {
+		if err := delta.Run(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "romrepo delta: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	configPath := flag.String("config", "", "path to config file (default: ~/.config/romrepo/config.yaml)")
 	flag.Parse()
 
@@ -22,10 +34,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	q, err := queue.Load(config.StatePath(*configPath, "queue.yaml"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading transfer queue: %v\n", err)
+		os.Exit(1)
+	}
+
+	history, err := stats.Load(config.StatePath(*configPath, "stats.yaml"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading transfer stats: %v\n", err)
+		os.Exit(1)
+	}
+
 	connMgr := remote.NewConnManager()
 	defer connMgr.CloseAll()
 
-	app := tui.NewApp(cfg, connMgr, *configPath)
+	app := tui.NewApp(cfg, connMgr, q, history, *configPath)
 	p := tea.NewProgram(app, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)